@@ -0,0 +1,380 @@
+// Package aes implements AES (Rijndael with 128 bit blocks) as defined in
+// FIPS-197, written from scratch so it can be attacked from the inside.
+//
+// Beyond the standard cipher.Block, a Cipher can run with any number of
+// rounds, exposes its key schedule, and calls a hook after every step of
+// every round. The hook can observe the state, or change it to inject
+// faults.
+//
+// It is slow and not constant time. Don't use it for anything real.
+package aes
+
+import (
+	"crypto/cipher"
+	"fmt"
+)
+
+// The AES block size in bytes.
+const BlockSize = 16
+
+// State is the 4x4 byte AES state. As in FIPS-197, byte r+4c is row r of
+// column c, so the state is laid out in the same order as the block.
+type State [BlockSize]byte
+
+// Step names a step of the cipher, for hooks
+type Step int
+
+const (
+	AddRoundKey Step = iota
+	SubBytes
+	ShiftRows
+	MixColumns
+	InvSubBytes
+	InvShiftRows
+	InvMixColumns
+)
+
+func (s Step) String() string {
+	switch s {
+	case AddRoundKey:
+		return "AddRoundKey"
+	case SubBytes:
+		return "SubBytes"
+	case ShiftRows:
+		return "ShiftRows"
+	case MixColumns:
+		return "MixColumns"
+	case InvSubBytes:
+		return "InvSubBytes"
+	case InvShiftRows:
+		return "InvShiftRows"
+	case InvMixColumns:
+		return "InvMixColumns"
+	}
+	return fmt.Sprintf("Step(%d)", int(s))
+}
+
+// Hook is called with the state after each step. Round 0 is the initial
+// AddRoundKey. When decrypting, round r undoes encryption round r, so the
+// state after its InvSubBytes is the encryption state at the end of round
+// r-1.
+type Hook func(round int, step Step, s *State)
+
+// KeySizeError is returned for keys which aren't 16, 24 or 32 bytes
+type KeySizeError int
+
+func (k KeySizeError) Error() string {
+	return fmt.Sprintf("aes: invalid key size %d", int(k))
+}
+
+// StandardRounds is the number of rounds FIPS-197 uses for a key size
+func StandardRounds(keySize int) (int, error) {
+	switch keySize {
+	case 16:
+		return 10, nil
+	case 24:
+		return 12, nil
+	case 32:
+		return 14, nil
+	}
+	return 0, KeySizeError(keySize)
+}
+
+type Cipher struct {
+	rounds    int
+	roundKeys []State
+	hook      Hook
+}
+
+// NewCipher creates standard AES, with the number of rounds set by the
+// key size
+func NewCipher(key []byte) (cipher.Block, error) {
+	rounds, err := StandardRounds(len(key))
+	if err != nil {
+		return nil, err
+	}
+	return New(key, rounds)
+}
+
+// New creates AES with a non-standard number of rounds. The key schedule
+// is extended (or cut short) to suit.
+func New(key []byte, rounds int) (*Cipher, error) {
+	roundKeys, err := ExpandKey(key, rounds)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{rounds: rounds, roundKeys: roundKeys}, nil
+}
+
+func (c *Cipher) BlockSize() int {
+	return BlockSize
+}
+
+func (c *Cipher) Rounds() int {
+	return c.rounds
+}
+
+// RoundKeys returns a copy of the key schedule, one key per round
+// starting with round 0
+func (c *Cipher) RoundKeys() []State {
+	rks := make([]State, len(c.roundKeys))
+	copy(rks, c.roundKeys)
+	return rks
+}
+
+// SetHook sets the hook called after each step. nil removes it.
+func (c *Cipher) SetHook(h Hook) {
+	c.hook = h
+}
+
+func (c *Cipher) step(round int, step Step, s *State) {
+	if c.hook != nil {
+		c.hook(round, step, s)
+	}
+}
+
+func (c *Cipher) Encrypt(dst, src []byte) {
+	if len(src) < BlockSize {
+		panic("aes: input not full block")
+	}
+	if len(dst) < BlockSize {
+		panic("aes: output not full block")
+	}
+	var s State
+	copy(s[:], src)
+
+	s.AddRoundKey(&c.roundKeys[0])
+	c.step(0, AddRoundKey, &s)
+	for r := 1; r <= c.rounds; r++ {
+		s.SubBytes()
+		c.step(r, SubBytes, &s)
+		s.ShiftRows()
+		c.step(r, ShiftRows, &s)
+		if r < c.rounds {
+			s.MixColumns()
+			c.step(r, MixColumns, &s)
+		}
+		s.AddRoundKey(&c.roundKeys[r])
+		c.step(r, AddRoundKey, &s)
+	}
+	copy(dst, s[:])
+}
+
+func (c *Cipher) Decrypt(dst, src []byte) {
+	if len(src) < BlockSize {
+		panic("aes: input not full block")
+	}
+	if len(dst) < BlockSize {
+		panic("aes: output not full block")
+	}
+	var s State
+	copy(s[:], src)
+
+	for r := c.rounds; r >= 1; r-- {
+		s.AddRoundKey(&c.roundKeys[r])
+		c.step(r, AddRoundKey, &s)
+		if r < c.rounds {
+			s.InvMixColumns()
+			c.step(r, InvMixColumns, &s)
+		}
+		s.InvShiftRows()
+		c.step(r, InvShiftRows, &s)
+		s.InvSubBytes()
+		c.step(r, InvSubBytes, &s)
+	}
+	s.AddRoundKey(&c.roundKeys[0])
+	c.step(0, AddRoundKey, &s)
+	copy(dst, s[:])
+}
+
+func (s *State) AddRoundKey(k *State) {
+	for i := range s {
+		s[i] ^= k[i]
+	}
+}
+
+func (s *State) SubBytes() {
+	for i, b := range s {
+		s[i] = SBox[b]
+	}
+}
+
+func (s *State) InvSubBytes() {
+	for i, b := range s {
+		s[i] = InvSBox[b]
+	}
+}
+
+// ShiftRows rotates row r left by r
+func (s *State) ShiftRows() {
+	t := *s
+	for r := 1; r < 4; r++ {
+		for c := 0; c < 4; c++ {
+			s[r+4*c] = t[r+4*((c+r)%4)]
+		}
+	}
+}
+
+func (s *State) InvShiftRows() {
+	t := *s
+	for r := 1; r < 4; r++ {
+		for c := 0; c < 4; c++ {
+			s[r+4*((c+r)%4)] = t[r+4*c]
+		}
+	}
+}
+
+// MixColumns multiplies each column by the fixed polynomial
+// {03}x^3 + {01}x^2 + {01}x + {02}
+func (s *State) MixColumns() {
+	for c := 0; c < 4; c++ {
+		a0, a1, a2, a3 := s[4*c], s[4*c+1], s[4*c+2], s[4*c+3]
+		s[4*c] = Mul(a0, 2) ^ Mul(a1, 3) ^ a2 ^ a3
+		s[4*c+1] = a0 ^ Mul(a1, 2) ^ Mul(a2, 3) ^ a3
+		s[4*c+2] = a0 ^ a1 ^ Mul(a2, 2) ^ Mul(a3, 3)
+		s[4*c+3] = Mul(a0, 3) ^ a1 ^ a2 ^ Mul(a3, 2)
+	}
+}
+
+func (s *State) InvMixColumns() {
+	for c := 0; c < 4; c++ {
+		a0, a1, a2, a3 := s[4*c], s[4*c+1], s[4*c+2], s[4*c+3]
+		s[4*c] = Mul(a0, 0x0e) ^ Mul(a1, 0x0b) ^ Mul(a2, 0x0d) ^ Mul(a3, 0x09)
+		s[4*c+1] = Mul(a0, 0x09) ^ Mul(a1, 0x0e) ^ Mul(a2, 0x0b) ^ Mul(a3, 0x0d)
+		s[4*c+2] = Mul(a0, 0x0d) ^ Mul(a1, 0x09) ^ Mul(a2, 0x0e) ^ Mul(a3, 0x0b)
+		s[4*c+3] = Mul(a0, 0x0b) ^ Mul(a1, 0x0d) ^ Mul(a2, 0x09) ^ Mul(a3, 0x0e)
+	}
+}
+
+// xtime multiplies by x in GF(2^8) mod x^8 + x^4 + x^3 + x + 1
+func xtime(a byte) byte {
+	if a&0x80 != 0 {
+		return a<<1 ^ 0x1b
+	}
+	return a << 1
+}
+
+// Mul multiplies in GF(2^8) with the AES polynomial
+func Mul(a, b byte) byte {
+	var p byte
+	for b != 0 {
+		if b&1 != 0 {
+			p ^= a
+		}
+		a = xtime(a)
+		b >>= 1
+	}
+	return p
+}
+
+// SBox and InvSBox are built at init from the field inverse and the
+// affine transform, rather than pasted in
+var SBox, InvSBox [256]byte
+
+func init() {
+	for i := 0; i < 256; i++ {
+		b := inverse(byte(i))
+		// b ^ rotl(b, 1) ^ rotl(b, 2) ^ rotl(b, 3) ^ rotl(b, 4) ^ 0x63
+		s := b ^ rotl8(b, 1) ^ rotl8(b, 2) ^ rotl8(b, 3) ^ rotl8(b, 4) ^ 0x63
+		SBox[i] = s
+		InvSBox[s] = byte(i)
+	}
+}
+
+func rotl8(b byte, n uint) byte {
+	return b<<n | b>>(8-n)
+}
+
+// inverse finds the multiplicative inverse in GF(2^8), with 0 mapping to
+// 0. a^254 = a^-1 since the multiplicative group has order 255.
+func inverse(a byte) byte {
+	p := byte(1)
+	for i := 0; i < 254; i++ {
+		p = Mul(p, a)
+	}
+	return p
+}
+
+// ExpandKey runs the FIPS-197 key expansion to produce rounds+1 round
+// keys
+func ExpandKey(key []byte, rounds int) ([]State, error) {
+	if _, err := StandardRounds(len(key)); err != nil {
+		return nil, err
+	}
+	if rounds < 1 {
+		return nil, fmt.Errorf("aes: invalid number of rounds %d", rounds)
+	}
+	nk := len(key) / 4
+	numWords := 4 * (rounds + 1)
+	if numWords < nk {
+		numWords = nk
+	}
+	w := make([][4]byte, numWords)
+	for i := 0; i < nk; i++ {
+		copy(w[i][:], key[4*i:])
+	}
+	rcon := byte(1)
+	for i := nk; i < numWords; i++ {
+		t := w[i-1]
+		if i%nk == 0 {
+			t = subWord(rotWord(t))
+			t[0] ^= rcon
+			rcon = xtime(rcon)
+		} else if nk > 6 && i%nk == 4 {
+			t = subWord(t)
+		}
+		for j := range t {
+			w[i][j] = w[i-nk][j] ^ t[j]
+		}
+	}
+
+	roundKeys := make([]State, rounds+1)
+	for r := range roundKeys {
+		for c := 0; c < 4; c++ {
+			copy(roundKeys[r][4*c:], w[4*r+c][:])
+		}
+	}
+	return roundKeys, nil
+}
+
+// rcon is the round constant used for word 4i of an AES-128 schedule
+func rcon(i int) byte {
+	c := byte(1)
+	for ; i > 1; i-- {
+		c = xtime(c)
+	}
+	return c
+}
+
+func rotWord(w [4]byte) [4]byte {
+	return [4]byte{w[1], w[2], w[3], w[0]}
+}
+
+func subWord(w [4]byte) [4]byte {
+	return [4]byte{SBox[w[0]], SBox[w[1]], SBox[w[2]], SBox[w[3]]}
+}
+
+// InvertKeySchedule recovers an AES-128 key from its round key for the
+// given round. Attacks which find the last round key use this to get the
+// key itself.
+func InvertKeySchedule(roundKey State, round int) []byte {
+	w := make([][4]byte, 4*(round+1))
+	for c := 0; c < 4; c++ {
+		copy(w[4*round+c][:], roundKey[4*c:])
+	}
+	for i := 4*round + 3; i >= 4; i-- {
+		t := w[i-1]
+		if i%4 == 0 {
+			t = subWord(rotWord(t))
+			t[0] ^= rcon(i / 4)
+		}
+		for j := range t {
+			w[i-4][j] = w[i][j] ^ t[j]
+		}
+	}
+	key := make([]byte, 16)
+	for i := 0; i < 4; i++ {
+		copy(key[4*i:], w[i][:])
+	}
+	return key
+}
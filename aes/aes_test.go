@@ -0,0 +1,186 @@
+package aes
+
+import (
+	stdaes "crypto/aes"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+)
+
+func deHex(t *testing.T, s string) []byte {
+	buf, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("Bad hex [%s]: %s", s, err)
+	}
+	return buf
+}
+
+func TestFIPS197Vectors(t *testing.T) {
+	// Appendix C
+	testCases := []struct {
+		key, expected string
+	}{
+		{"000102030405060708090a0b0c0d0e0f", "69c4e0d86a7b0430d8cdb78070b4c55a"},
+		{"000102030405060708090a0b0c0d0e0f1011121314151617", "dda97ca4864cdfe06eaf70a0ec0d7191"},
+		{"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f", "8ea2b7ca516745bfeafc49904b496089"},
+	}
+	plainText := deHex(t, "00112233445566778899aabbccddeeff")
+	for _, tc := range testCases {
+		c, err := NewCipher(deHex(t, tc.key))
+		if err != nil {
+			t.Fatalf("Can't create cipher: %s", err)
+		}
+		got := make([]byte, BlockSize)
+		c.Encrypt(got, plainText)
+		if hex.EncodeToString(got) != tc.expected {
+			t.Fatalf("Key %s: got %x expected %s", tc.key, got, tc.expected)
+		}
+		c.Decrypt(got, got)
+		if hex.EncodeToString(got) != hex.EncodeToString(plainText) {
+			t.Fatalf("Key %s: decrypted to %x", tc.key, got)
+		}
+	}
+
+	_, err := NewCipher(make([]byte, 15))
+	if err == nil {
+		t.Fatalf("Didn't error on bad key size")
+	}
+}
+
+func TestKeySchedule(t *testing.T) {
+	// Appendix A.1
+	key := deHex(t, "2b7e151628aed2a6abf7158809cf4f3c")
+	c, err := New(key, 10)
+	if err != nil {
+		t.Fatalf("Can't create cipher: %s", err)
+	}
+	rks := c.RoundKeys()
+	if len(rks) != 11 {
+		t.Fatalf("Got %d round keys", len(rks))
+	}
+	expected := "d014f9a8c9ee2589e13f0cc8b6630ca6"
+	if hex.EncodeToString(rks[10][:]) != expected {
+		t.Fatalf("Got last round key %x expected %s", rks[10], expected)
+	}
+
+	for r := range rks {
+		got := InvertKeySchedule(rks[r], r)
+		if hex.EncodeToString(got) != hex.EncodeToString(key) {
+			t.Fatalf("Round %d: inverted to %x", r, got)
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	// Appendix B
+	key := deHex(t, "2b7e151628aed2a6abf7158809cf4f3c")
+	in := deHex(t, "3243f6a8885a308d313198a2e0370734")
+	c, _ := New(key, 10)
+
+	seen := make(map[Step]int)
+	var afterRound0, afterSubBytes1 State
+	c.SetHook(func(round int, step Step, s *State) {
+		seen[step]++
+		if round == 0 && step == AddRoundKey {
+			afterRound0 = *s
+		}
+		if round == 1 && step == SubBytes {
+			afterSubBytes1 = *s
+		}
+	})
+	out := make([]byte, BlockSize)
+	c.Encrypt(out, in)
+	if hex.EncodeToString(out) != "3925841d02dc09fbdc118597196a0b32" {
+		t.Fatalf("Wrong output %x", out)
+	}
+	if hex.EncodeToString(afterRound0[:]) != "193de3bea0f4e22b9ac68d2ae9f84808" {
+		t.Fatalf("Wrong round 0 state %x", afterRound0)
+	}
+	if hex.EncodeToString(afterSubBytes1[:]) != "d42711aee0bf98f1b8b45de51e415230" {
+		t.Fatalf("Wrong round 1 SubBytes state %x", afterSubBytes1)
+	}
+	if seen[AddRoundKey] != 11 || seen[SubBytes] != 10 || seen[MixColumns] != 9 {
+		t.Fatalf("Wrong step counts %v", seen)
+	}
+
+	// Undoing round 1 gets back to the end of round 0
+	var decRound0 State
+	c.SetHook(func(round int, step Step, s *State) {
+		if round == 1 && step == InvSubBytes {
+			decRound0 = *s
+		}
+		if round == 0 && step == AddRoundKey {
+			*s = State{}
+		}
+	})
+	got := make([]byte, BlockSize)
+	c.Decrypt(got, out)
+	if decRound0 != afterRound0 {
+		t.Fatalf("Decrypt hook saw %x expected %x", decRound0, afterRound0)
+	}
+	// The hook zeroed the final state
+	if got[0] != 0 || got[15] != 0 {
+		t.Fatalf("Hook didn't replace state: %x", got)
+	}
+
+	// A fault before the last MixColumns spreads to exactly one column
+	c.SetHook(func(round int, step Step, s *State) {
+		if round == 9 && step == ShiftRows {
+			s[0] ^= 1
+		}
+	})
+	faulty := make([]byte, BlockSize)
+	c.Encrypt(faulty, in)
+	diff := 0
+	for i := range faulty {
+		if faulty[i] != out[i] {
+			diff++
+		}
+	}
+	if diff != 4 {
+		t.Fatalf("Fault changed %d bytes, expected 4", diff)
+	}
+}
+
+func TestMatchesStdlib(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, keySize := range []int{16, 24, 32} {
+		key := make([]byte, keySize)
+		rng.Read(key)
+		ours, _ := NewCipher(key)
+		theirs, _ := stdaes.NewCipher(key)
+
+		in := make([]byte, BlockSize)
+		for i := 0; i < 100; i++ {
+			rng.Read(in)
+			a := make([]byte, BlockSize)
+			b := make([]byte, BlockSize)
+			ours.Encrypt(a, in)
+			theirs.Encrypt(b, in)
+			if hex.EncodeToString(a) != hex.EncodeToString(b) {
+				t.Fatalf("Key %x in %x: got %x expected %x", key, in, a, b)
+			}
+		}
+	}
+}
+
+func TestReducedRounds(t *testing.T) {
+	key := make([]byte, 16)
+	in := make([]byte, BlockSize)
+	for rounds := 1; rounds <= 16; rounds++ {
+		c, err := New(key, rounds)
+		if err != nil {
+			t.Fatalf("Can't create %d round cipher: %s", rounds, err)
+		}
+		out := make([]byte, BlockSize)
+		c.Encrypt(out, in)
+		c.Decrypt(out, out)
+		if hex.EncodeToString(out) != hex.EncodeToString(in) {
+			t.Fatalf("%d rounds: decrypted to %x", rounds, out)
+		}
+	}
+	_, err := New(key, 0)
+	if err == nil {
+		t.Fatalf("Didn't error on zero rounds")
+	}
+}
@@ -0,0 +1,334 @@
+// Package cbcmac implements CBC-MAC, a toy bank which uses it to
+// authenticate money transfers, and the classic forgeries against it.
+//
+// The bank comes in two flavours. In one the client sends the IV with each
+// request, which lets an attacker rewrite the first block. In the other
+// the IV is fixed, which lets an attacker splice two MAC'd messages
+// together.
+package cbcmac
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jbert/cpals-go"
+)
+
+// MAC is the last block of the CBC encryption of the PKCS#7 padded message
+func MAC(b cipher.Block, iv, msg []byte) ([]byte, error) {
+	enc, err := cpals.NewCBCEncrypter(b, iv)
+	if err != nil {
+		return nil, err
+	}
+	padded := cpals.PKCS7Padder{}.Pad(msg, b.BlockSize())
+	enc.CryptBlocks(padded, padded)
+	return padded[len(padded)-b.BlockSize():], nil
+}
+
+// Transfer moves Amount to account To
+type Transfer struct {
+	To     int
+	Amount int
+}
+
+// Order is a parsed request: transfers from one account
+type Order struct {
+	From      int
+	Transfers []Transfer
+}
+
+// EncodeTransfer is the single transfer format
+// "from=#{from}&to=#{to}&amount=#{amount}"
+func EncodeTransfer(from int, t Transfer) []byte {
+	return []byte(fmt.Sprintf("from=%d&to=%d&amount=%d", from, t.To, t.Amount))
+}
+
+// EncodeTransferList is the multiple transfer format
+// "from=#{from}&tx_list=#{to}:#{amount}(;#{to}:#{amount})*"
+func EncodeTransferList(from int, ts []Transfer) []byte {
+	var txs []string
+	for _, t := range ts {
+		txs = append(txs, fmt.Sprintf("%d:%d", t.To, t.Amount))
+	}
+	return []byte(fmt.Sprintf("from=%d&tx_list=%s", from, strings.Join(txs, ";")))
+}
+
+// ParseOrder reads either format. Like many hand rolled parsers, it skips
+// fields and transactions it can't make sense of rather than rejecting the
+// whole message.
+func ParseOrder(msg []byte) (Order, error) {
+	var o Order
+	var to, amount string
+	fromOK := false
+	for _, field := range bytes.Split(msg, []byte("&")) {
+		kv := strings.SplitN(string(field), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "from":
+			from, err := strconv.Atoi(kv[1])
+			if err != nil {
+				return o, fmt.Errorf("Bad from [%s]: %w", kv[1], err)
+			}
+			o.From = from
+			fromOK = true
+		case "to":
+			to = kv[1]
+		case "amount":
+			amount = kv[1]
+		case "tx_list":
+			for _, tx := range strings.Split(kv[1], ";") {
+				parts := strings.Split(tx, ":")
+				if len(parts) != 2 {
+					continue
+				}
+				t, err := parseTransfer(parts[0], parts[1])
+				if err != nil {
+					continue
+				}
+				o.Transfers = append(o.Transfers, t)
+			}
+		}
+	}
+	if !fromOK {
+		return o, errors.New("No from account")
+	}
+	if to != "" || amount != "" {
+		t, err := parseTransfer(to, amount)
+		if err != nil {
+			return o, err
+		}
+		o.Transfers = append(o.Transfers, t)
+	}
+	if len(o.Transfers) == 0 {
+		return o, errors.New("No transfers")
+	}
+	return o, nil
+}
+
+func parseTransfer(to, amount string) (Transfer, error) {
+	var t Transfer
+	var err error
+	t.To, err = strconv.Atoi(to)
+	if err != nil {
+		return t, fmt.Errorf("Bad to [%s]: %w", to, err)
+	}
+	t.Amount, err = strconv.Atoi(amount)
+	if err != nil {
+		return t, fmt.Errorf("Bad amount [%s]: %w", amount, err)
+	}
+	if t.Amount < 0 {
+		return t, fmt.Errorf("Negative amount %d", t.Amount)
+	}
+	return t, nil
+}
+
+// Request is a MAC'd message as sent to the server. IV is nil when the
+// bank uses a fixed IV.
+type Request struct {
+	Msg []byte
+	IV  []byte
+	MAC []byte
+}
+
+// Bytes is the wire format: message || IV || MAC, or message || MAC
+func (r Request) Bytes() []byte {
+	var buf []byte
+	buf = append(buf, r.Msg...)
+	buf = append(buf, r.IV...)
+	return append(buf, r.MAC...)
+}
+
+// ParseRequest splits the wire format, which includes the IV if withIV
+func ParseRequest(buf []byte, withIV bool) (Request, error) {
+	var r Request
+	n := aes.BlockSize
+	if withIV {
+		n *= 2
+	}
+	if len(buf) < n {
+		return r, fmt.Errorf("Request len %d too short", len(buf))
+	}
+	msgLen := len(buf) - n
+	r.Msg = buf[:msgLen]
+	if withIV {
+		r.IV = buf[msgLen : msgLen+aes.BlockSize]
+	}
+	r.MAC = buf[len(buf)-aes.BlockSize:]
+	return r, nil
+}
+
+// bank holds what the client and server share
+type bank struct {
+	b cipher.Block
+	// fixedIV is nil when each request carries its own IV
+	fixedIV []byte
+}
+
+func newBank(key, fixedIV []byte) (bank, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return bank{}, fmt.Errorf("Can't create aes cipher: %w", err)
+	}
+	if fixedIV != nil && len(fixedIV) != b.BlockSize() {
+		return bank{}, fmt.Errorf("iv length must match blocksize %d != %d", len(fixedIV), b.BlockSize())
+	}
+	return bank{b: b, fixedIV: fixedIV}, nil
+}
+
+// Client is the bank's web front end. It shares the MAC key with the
+// server, and signs transfers from whichever user is logged in.
+type Client struct {
+	bank
+	user int
+}
+
+// NewClient logs in as user. A nil fixedIV means each request carries a
+// random IV.
+func NewClient(key, fixedIV []byte, user int) (*Client, error) {
+	bk, err := newBank(key, fixedIV)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{bank: bk, user: user}, nil
+}
+
+func (c *Client) sign(msg []byte) Request {
+	r := Request{Msg: msg}
+	iv := c.fixedIV
+	if iv == nil {
+		r.IV = cpals.RandomBytes(c.b.BlockSize())
+		iv = r.IV
+	}
+	mac, err := MAC(c.b, iv, msg)
+	if err != nil {
+		panic(fmt.Sprintf("Internal error: %s", err))
+	}
+	r.MAC = mac
+	return r
+}
+
+// Transfer signs a single transfer from the logged in user
+func (c *Client) Transfer(t Transfer) Request {
+	return c.sign(EncodeTransfer(c.user, t))
+}
+
+// TransferList signs several transfers from the logged in user
+func (c *Client) TransferList(ts []Transfer) Request {
+	return c.sign(EncodeTransferList(c.user, ts))
+}
+
+// Server verifies requests and moves money
+type Server struct {
+	bank
+	balances map[int]int
+}
+
+// NewServer starts a bank holding balances
+func NewServer(key, fixedIV []byte, balances map[int]int) (*Server, error) {
+	bk, err := newBank(key, fixedIV)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{bank: bk, balances: make(map[int]int)}
+	for k, v := range balances {
+		s.balances[k] = v
+	}
+	return s, nil
+}
+
+func (s *Server) Balance(account int) int {
+	return s.balances[account]
+}
+
+// Submit checks a request in wire format and carries out its transfers.
+// Either all of them happen, or none.
+func (s *Server) Submit(buf []byte) error {
+	r, err := ParseRequest(buf, s.fixedIV == nil)
+	if err != nil {
+		return err
+	}
+	iv := s.fixedIV
+	if iv == nil {
+		iv = r.IV
+	}
+	mac, err := MAC(s.b, iv, r.Msg)
+	if err != nil {
+		return fmt.Errorf("Can't MAC: %w", err)
+	}
+	if subtle.ConstantTimeCompare(mac, r.MAC) != 1 {
+		return errors.New("Bad MAC")
+	}
+	o, err := ParseOrder(r.Msg)
+	if err != nil {
+		return fmt.Errorf("Can't parse order: %w", err)
+	}
+	total := 0
+	for _, t := range o.Transfers {
+		total += t.Amount
+	}
+	if total > s.balances[o.From] {
+		return fmt.Errorf("Insufficient funds in %d: %d < %d", o.From, s.balances[o.From], total)
+	}
+	for _, t := range o.Transfers {
+		s.balances[o.From] -= t.Amount
+		s.balances[t.To] += t.Amount
+	}
+	return nil
+}
+
+// ForgeFirstBlock replaces the first block of a request which carries its
+// own IV. The first block is XORed with the IV before encryption, so
+// making the same change to the IV leaves the MAC unchanged.
+func ForgeFirstBlock(r Request, first []byte) (Request, error) {
+	bs := len(r.IV)
+	if bs == 0 {
+		return r, errors.New("Request has no IV to change")
+	}
+	if len(first) != bs || len(r.Msg) < bs {
+		return r, fmt.Errorf("Need a whole first block of %d bytes", bs)
+	}
+	iv := make([]byte, bs)
+	for i := range iv {
+		iv[i] = r.IV[i] ^ r.Msg[i] ^ first[i]
+	}
+	msg := make([]byte, len(r.Msg))
+	copy(msg, first)
+	copy(msg[bs:], r.Msg[bs:])
+	return Request{Msg: msg, IV: iv, MAC: r.MAC}, nil
+}
+
+// Splice glues ext on to the end of r. After r and its padding the CBC
+// state is r's MAC, so XORing that and ext's IV in to ext's first block
+// puts the chain back where ext started, and the result has ext's MAC.
+// r's padding and ext's first block are left as garbage in the middle of
+// the message. Requests which carry their own IV use it, and the result
+// carries r's; fixedIV is used for those which don't.
+func Splice(r, ext Request, fixedIV []byte) (Request, error) {
+	bs := len(r.MAC)
+	if len(ext.Msg) < bs {
+		return r, fmt.Errorf("Extension len %d shorter than a block", len(ext.Msg))
+	}
+	iv := ext.IV
+	if iv == nil {
+		iv = fixedIV
+	}
+	if len(iv) != bs {
+		return r, fmt.Errorf("iv length must match blocksize %d != %d", len(iv), bs)
+	}
+	msg := cpals.PKCS7Padder{}.Pad(r.Msg, bs)
+	first := make([]byte, bs)
+	for i := range first {
+		first[i] = ext.Msg[i] ^ iv[i] ^ r.MAC[i]
+	}
+	msg = append(msg, first...)
+	msg = append(msg, ext.Msg[bs:]...)
+	return Request{Msg: msg, IV: r.IV, MAC: ext.MAC}, nil
+}
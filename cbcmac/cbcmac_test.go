@@ -0,0 +1,149 @@
+package cbcmac
+
+import (
+	"crypto/aes"
+	"testing"
+
+	"github.com/jbert/cpals-go"
+)
+
+const (
+	victim   = 1001
+	attacker = 1002
+	friend   = 1003
+	million  = 1000000
+)
+
+func TestMAC(t *testing.T) {
+	b, _ := aes.NewCipher(cpals.YellowKey)
+	iv := make([]byte, aes.BlockSize)
+	mac, err := MAC(b, iv, []byte("alert('MZA who was that?');\n"))
+	if err != nil {
+		t.Fatalf("Can't MAC: %s", err)
+	}
+	// Challenge 50's hash
+	if string(cpals.EnHex(mac)) != "296b8d7cb78a243dda4d0a61d33bbdd1" {
+		t.Fatalf("Got MAC %s", cpals.EnHex(mac))
+	}
+}
+
+func TestParseOrder(t *testing.T) {
+	o, err := ParseOrder(EncodeTransferList(victim, []Transfer{{friend, 10}, {attacker, 20}}))
+	if err != nil || o.From != victim || len(o.Transfers) != 2 || o.Transfers[1] != (Transfer{attacker, 20}) {
+		t.Fatalf("Bad parse %+v: %s", o, err)
+	}
+	o, err = ParseOrder(EncodeTransfer(victim, Transfer{friend, 10}))
+	if err != nil || o.From != victim || len(o.Transfers) != 1 || o.Transfers[0] != (Transfer{friend, 10}) {
+		t.Fatalf("Bad parse %+v: %s", o, err)
+	}
+
+	for _, bad := range []string{
+		"to=1003&amount=10",
+		"from=1001&to=1003&amount=-10",
+		"from=1001&to=bob&amount=10",
+		"from=1001&tx_list=bob:10",
+	} {
+		_, err := ParseOrder([]byte(bad))
+		if err == nil {
+			t.Fatalf("Didn't error on %s", bad)
+		}
+		t.Logf("errored ok: %s", err)
+	}
+}
+
+func newTestBank(t *testing.T, fixedIV []byte) (*Server, *Client, *Client) {
+	key := cpals.RandomKey()
+	s, err := NewServer(key, fixedIV, map[int]int{victim: million + 100})
+	if err != nil {
+		t.Fatalf("Can't create server: %s", err)
+	}
+	v, _ := NewClient(key, fixedIV, victim)
+	a, _ := NewClient(key, fixedIV, attacker)
+	return s, v, a
+}
+
+func TestServer(t *testing.T) {
+	s, v, a := newTestBank(t, nil)
+	err := s.Submit(v.Transfer(Transfer{friend, 10}).Bytes())
+	if err != nil {
+		t.Fatalf("Can't transfer: %s", err)
+	}
+	if s.Balance(friend) != 10 || s.Balance(victim) != million+90 {
+		t.Fatalf("Bad balances %d %d", s.Balance(friend), s.Balance(victim))
+	}
+
+	req := v.Transfer(Transfer{friend, 10})
+	req.Msg = []byte("from=1001&to=1002&amount=10")
+	err = s.Submit(req.Bytes())
+	if err == nil {
+		t.Fatalf("Didn't error on changed message")
+	}
+	t.Logf("errored ok: %s", err)
+
+	err = s.Submit(a.Transfer(Transfer{friend, 10}).Bytes())
+	if err == nil {
+		t.Fatalf("Didn't error on overdraft")
+	}
+	t.Logf("errored ok: %s", err)
+}
+
+func TestForgeFirstBlock(t *testing.T) {
+	s, _, a := newTestBank(t, nil)
+
+	// Get the attacker's own transfer signed, then make it from the victim
+	req := a.Transfer(Transfer{attacker, million})
+	first := EncodeTransfer(victim, Transfer{attacker, million})[:aes.BlockSize]
+	forged, err := ForgeFirstBlock(req, first)
+	if err != nil {
+		t.Fatalf("Can't forge: %s", err)
+	}
+	t.Logf("Forged %s", forged.Msg)
+	err = s.Submit(forged.Bytes())
+	if err != nil {
+		t.Fatalf("Forgery rejected: %s", err)
+	}
+	if s.Balance(attacker) != million {
+		t.Fatalf("Attacker has %d", s.Balance(attacker))
+	}
+}
+
+func TestSplice(t *testing.T) {
+	testCases := []struct {
+		name    string
+		fixedIV []byte
+	}{
+		{"zero IV", make([]byte, aes.BlockSize)},
+		{"random IV", cpals.RandomBytes(aes.BlockSize)},
+		{"IV per request", nil},
+	}
+	for _, tc := range testCases {
+		testSplice(t, tc.name, tc.fixedIV)
+	}
+}
+
+func testSplice(t *testing.T, name string, fixedIV []byte) {
+	s, v, a := newTestBank(t, fixedIV)
+
+	// The attacker's first block gets garbled, the rest is appended to the
+	// victim's tx_list. If the garbage happens to hold an '&' it cuts the
+	// tx_list short, so try another captured message.
+	ext := a.TransferList([]Transfer{{attacker, 1}, {attacker, million}})
+	for i := 0; i < 20; i++ {
+		captured := v.TransferList([]Transfer{{friend, 10 + i}})
+		forged, err := Splice(captured, ext, fixedIV)
+		if err != nil {
+			t.Fatalf("%s: Can't splice: %s", name, err)
+		}
+		err = s.Submit(forged.Bytes())
+		if err != nil {
+			t.Logf("%s: Forgery %d rejected: %s", name, i, err)
+			continue
+		}
+		if s.Balance(attacker) < million {
+			t.Fatalf("%s: Attacker only has %d", name, s.Balance(attacker))
+		}
+		t.Logf("%s: Forged %q", name, forged.Msg)
+		return
+	}
+	t.Fatalf("%s: Couldn't splice", name)
+}
@@ -0,0 +1,402 @@
+// Package classical has the pen-and-paper ciphers - Caesar, Vigenère,
+// affine and simple substitution - with breakers built on the cpals
+// scorers and key length estimators.
+//
+// All of them work on the 26 letters A-Z. Case is preserved and anything
+// which isn't a letter passes through unchanged (and doesn't use up a key
+// letter).
+package classical
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/jbert/cpals-go"
+)
+
+const alphabetSize = 26
+
+// letterIndex gives the position of b in the alphabet, and the case offset
+// to restore it
+func letterIndex(b byte) (int, byte, bool) {
+	switch {
+	case b >= 'A' && b <= 'Z':
+		return int(b - 'A'), 'A', true
+	case b >= 'a' && b <= 'z':
+		return int(b - 'a'), 'a', true
+	}
+	return 0, 0, false
+}
+
+func mod(a, n int) int {
+	return ((a % n) + n) % n
+}
+
+// mapLetters replaces the i'th letter x of msg with f(i, x)
+func mapLetters(msg []byte, f func(i, x int) int) []byte {
+	out := make([]byte, len(msg))
+	i := 0
+	for j, b := range msg {
+		x, base, ok := letterIndex(b)
+		if !ok {
+			out[j] = b
+			continue
+		}
+		out[j] = base + byte(f(i, x))
+		i++
+	}
+	return out
+}
+
+// letters extracts the letters of msg, upper cased
+func letters(msg []byte) []byte {
+	var ls []byte
+	for _, b := range msg {
+		if x, _, ok := letterIndex(b); ok {
+			ls = append(ls, 'A'+byte(x))
+		}
+	}
+	return ls
+}
+
+// keyShifts turns a key of letters into shifts
+func keyShifts(key []byte) ([]int, error) {
+	if len(key) == 0 {
+		return nil, errors.New("Empty key")
+	}
+	shifts := make([]int, len(key))
+	for i, b := range key {
+		x, _, ok := letterIndex(b)
+		if !ok {
+			return nil, fmt.Errorf("Key byte %d (%q) is not a letter", i, b)
+		}
+		shifts[i] = x
+	}
+	return shifts, nil
+}
+
+func CaesarEncrypt(msg []byte, shift int) []byte {
+	return mapLetters(msg, func(_, x int) int {
+		return mod(x+shift, alphabetSize)
+	})
+}
+
+func CaesarDecrypt(ctxt []byte, shift int) []byte {
+	return CaesarEncrypt(ctxt, -shift)
+}
+
+// BreakCaesar tries every shift and returns the best scoring
+func BreakCaesar(ctxt []byte, scorer cpals.Scorer) (int, []byte, float64) {
+	bestShift := 0
+	var bestMsg []byte
+	bestScore := math.Inf(-1)
+	for shift := 0; shift < alphabetSize; shift++ {
+		msg := CaesarDecrypt(ctxt, shift)
+		score := scorer.Score(msg)
+		if score > bestScore {
+			bestShift, bestMsg, bestScore = shift, msg, score
+		}
+	}
+	return bestShift, bestMsg, bestScore
+}
+
+// VigenereEncrypt shifts each letter by the next letter of the key
+func VigenereEncrypt(msg, key []byte) ([]byte, error) {
+	shifts, err := keyShifts(key)
+	if err != nil {
+		return nil, err
+	}
+	return mapLetters(msg, func(i, x int) int {
+		return mod(x+shifts[i%len(shifts)], alphabetSize)
+	}), nil
+}
+
+func VigenereDecrypt(ctxt, key []byte) ([]byte, error) {
+	shifts, err := keyShifts(key)
+	if err != nil {
+		return nil, err
+	}
+	return mapLetters(ctxt, func(i, x int) int {
+		return mod(x-shifts[i%len(shifts)], alphabetSize)
+	}), nil
+}
+
+type VigenereOpts struct {
+	// Key length range. Default to 2 and 20
+	MinKeyLen, MaxKeyLen int
+	// How many of the best key lengths to solve. Defaults to 3
+	NumKeyLens int
+	// Defaults to a combination of the Hamming distance and index of
+	// coincidence estimators
+	Estimator cpals.KeyLengthEstimator
+	// Scores each column of letters when solving it as a Caesar cipher.
+	// Defaults to the letter frequencies of cpals.Hamlet
+	ColumnScorer cpals.Scorer
+	// Scores whole plaintexts to pick the key length. Defaults to
+	// cpals.EnglishScorer
+	Scorer cpals.Scorer
+}
+
+func (o VigenereOpts) defaults() VigenereOpts {
+	if o.MinKeyLen == 0 {
+		o.MinKeyLen = 2
+	}
+	if o.MaxKeyLen == 0 {
+		o.MaxKeyLen = 20
+	}
+	if o.NumKeyLens == 0 {
+		o.NumKeyLens = 3
+	}
+	if o.Estimator == nil {
+		o.Estimator = cpals.CombinedEstimator{
+			Estimators: []cpals.KeyLengthEstimator{
+				cpals.HammingEstimator{},
+				cpals.IndexOfCoincidenceEstimator{},
+			},
+		}
+	}
+	if o.ColumnScorer == nil {
+		o.ColumnScorer = cpals.ChiSquaredScorer{NGramModel: cpals.TrainNGramModel(1, letters(cpals.Hamlet))}
+	}
+	if o.Scorer == nil {
+		o.Scorer = cpals.EnglishScorer
+	}
+	return o
+}
+
+// BreakVigenere estimates the key length from the letters of the
+// ciphertext, then solves each column of letters as a Caesar cipher. The
+// best few key lengths are tried and the key giving the best scoring
+// plaintext wins.
+func BreakVigenere(ctxt []byte, opts VigenereOpts) ([]byte, []byte, error) {
+	opts = opts.defaults()
+	ls := letters(ctxt)
+	keyLens, err := cpals.RankKeyLengths(opts.Estimator, ls, opts.MinKeyLen, opts.MaxKeyLen)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Can't estimate key length: %w", err)
+	}
+	if len(keyLens) > opts.NumKeyLens {
+		keyLens = keyLens[:opts.NumKeyLens]
+	}
+
+	var bestKey, bestMsg []byte
+	bestScore := math.Inf(-1)
+	for _, keyLen := range keyLens {
+		key := make([]byte, keyLen)
+		for i, column := range cpals.XorKeyColumns(ls, keyLen) {
+			shift, _, _ := BreakCaesar(column, opts.ColumnScorer)
+			key[i] = 'A' + byte(shift)
+		}
+		// A repeat of a shorter key decrypts the same way
+		key = cpals.MinimalPeriod(key)
+		msg, err := VigenereDecrypt(ctxt, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		score := opts.Scorer.Score(msg)
+		if score > bestScore || (score == bestScore && len(key) < len(bestKey)) {
+			bestKey, bestMsg, bestScore = key, msg, score
+		}
+	}
+	return bestKey, bestMsg, nil
+}
+
+// modInverse finds the inverse of a mod 26, if there is one
+func modInverse(a int) (int, bool) {
+	a = mod(a, alphabetSize)
+	for x := 1; x < alphabetSize; x++ {
+		if a*x%alphabetSize == 1 {
+			return x, true
+		}
+	}
+	return 0, false
+}
+
+// AffineEncrypt maps letter x to ax + b. a must be coprime to 26.
+func AffineEncrypt(msg []byte, a, b int) ([]byte, error) {
+	if _, ok := modInverse(a); !ok {
+		return nil, fmt.Errorf("Multiplier %d not coprime to %d", a, alphabetSize)
+	}
+	return mapLetters(msg, func(_, x int) int {
+		return mod(a*x+b, alphabetSize)
+	}), nil
+}
+
+func AffineDecrypt(ctxt []byte, a, b int) ([]byte, error) {
+	aInv, ok := modInverse(a)
+	if !ok {
+		return nil, fmt.Errorf("Multiplier %d not coprime to %d", a, alphabetSize)
+	}
+	return mapLetters(ctxt, func(_, x int) int {
+		return mod(aInv*(x-b), alphabetSize)
+	}), nil
+}
+
+// BreakAffine tries all 312 keys and returns the best scoring
+func BreakAffine(ctxt []byte, scorer cpals.Scorer) (int, int, []byte) {
+	bestA, bestB := 1, 0
+	var bestMsg []byte
+	bestScore := math.Inf(-1)
+	for a := 1; a < alphabetSize; a++ {
+		if _, ok := modInverse(a); !ok {
+			continue
+		}
+		for b := 0; b < alphabetSize; b++ {
+			msg, _ := AffineDecrypt(ctxt, a, b)
+			score := scorer.Score(msg)
+			if score > bestScore {
+				bestA, bestB, bestMsg, bestScore = a, b, msg, score
+			}
+		}
+	}
+	return bestA, bestB, bestMsg
+}
+
+// checkSubstitutionKey checks key is a permutation of the alphabet
+func checkSubstitutionKey(key []byte) ([]int, error) {
+	if len(key) != alphabetSize {
+		return nil, fmt.Errorf("Key len %d != %d", len(key), alphabetSize)
+	}
+	perm, err := keyShifts(key)
+	if err != nil {
+		return nil, err
+	}
+	var seen [alphabetSize]bool
+	for i, x := range perm {
+		if seen[x] {
+			return nil, fmt.Errorf("Key byte %d (%q) repeated", i, key[i])
+		}
+		seen[x] = true
+	}
+	return perm, nil
+}
+
+// SubstitutionEncrypt replaces the i'th letter of the alphabet with the
+// i'th letter of key
+func SubstitutionEncrypt(msg, key []byte) ([]byte, error) {
+	perm, err := checkSubstitutionKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return mapLetters(msg, func(_, x int) int {
+		return perm[x]
+	}), nil
+}
+
+func SubstitutionDecrypt(ctxt, key []byte) ([]byte, error) {
+	perm, err := checkSubstitutionKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var inv [alphabetSize]int
+	for i, x := range perm {
+		inv[x] = i
+	}
+	return mapLetters(ctxt, func(_, x int) int {
+		return inv[x]
+	}), nil
+}
+
+type SubstitutionOpts struct {
+	// Defaults to the trigram log likelihood of cpals.Hamlet, which is
+	// too short a corpus for short ciphertexts. A model trained on more
+	// text does much better.
+	Scorer cpals.Scorer
+	// Number of hill climbs from different starting keys. Defaults to 10
+	Restarts int
+	// Defaults to a fixed seed, so breaks are repeatable
+	Rand *rand.Rand
+}
+
+func (o SubstitutionOpts) defaults() SubstitutionOpts {
+	if o.Scorer == nil {
+		o.Scorer = cpals.LogLikelihoodScorer{NGramModel: cpals.TrainNGramModel(3, cpals.Hamlet)}
+	}
+	if o.Restarts == 0 {
+		o.Restarts = 10
+	}
+	if o.Rand == nil {
+		o.Rand = rand.New(rand.NewSource(1))
+	}
+	return o
+}
+
+// BreakSubstitution hill-climbs over keys. The first climb starts from
+// the key which matches ciphertext letter frequencies to English, later
+// ones from increasingly shuffled versions of it.
+func BreakSubstitution(ctxt []byte, opts SubstitutionOpts) ([]byte, []byte, error) {
+	opts = opts.defaults()
+	if len(letters(ctxt)) == 0 {
+		return nil, nil, errors.New("No letters in ciphertext")
+	}
+	start := frequencyKey(ctxt)
+
+	var bestKey, bestMsg []byte
+	bestScore := math.Inf(-1)
+	for r := 0; r < opts.Restarts; r++ {
+		key := make([]byte, alphabetSize)
+		copy(key, start)
+		if r > 0 {
+			for i := 0; i < r; i++ {
+				a, b := opts.Rand.Intn(alphabetSize), opts.Rand.Intn(alphabetSize)
+				key[a], key[b] = key[b], key[a]
+			}
+		}
+		key, msg, score := climb(ctxt, key, opts.Scorer)
+		if score > bestScore {
+			bestKey, bestMsg, bestScore = key, msg, score
+		}
+	}
+	return bestKey, bestMsg, nil
+}
+
+// climb tries every swap of two key letters, keeping those which improve
+// the score, until a full pass makes no improvement
+func climb(ctxt, key []byte, scorer cpals.Scorer) ([]byte, []byte, float64) {
+	msg, _ := SubstitutionDecrypt(ctxt, key)
+	score := scorer.Score(msg)
+	for improved := true; improved; {
+		improved = false
+		for i := 0; i < alphabetSize; i++ {
+			for j := i + 1; j < alphabetSize; j++ {
+				key[i], key[j] = key[j], key[i]
+				trial, _ := SubstitutionDecrypt(ctxt, key)
+				trialScore := scorer.Score(trial)
+				if trialScore > score {
+					msg, score = trial, trialScore
+					improved = true
+				} else {
+					key[i], key[j] = key[j], key[i]
+				}
+			}
+		}
+	}
+	return key, msg, score
+}
+
+// englishOrder is the alphabet from most to least frequent in English
+var englishOrder = []byte("ETAOINSHRDLCUMWFGYPBVKJXQZ")
+
+// frequencyKey guesses an encryption key which sends the most common
+// English letter to the most common ciphertext letter, and so on
+func frequencyKey(ctxt []byte) []byte {
+	var counts [alphabetSize]int
+	for _, b := range letters(ctxt) {
+		counts[b-'A']++
+	}
+	byFreq := make([]int, alphabetSize)
+	for i := range byFreq {
+		byFreq[i] = i
+	}
+	sort.SliceStable(byFreq, func(i, j int) bool {
+		return counts[byFreq[i]] > counts[byFreq[j]]
+	})
+	key := make([]byte, alphabetSize)
+	for rank, x := range byFreq {
+		key[englishOrder[rank]-'A'] = 'A' + byte(x)
+	}
+	return key
+}
@@ -0,0 +1,112 @@
+package classical
+
+import (
+	"testing"
+
+	"github.com/jbert/cpals-go"
+)
+
+const sample = `It was the best of times, it was the worst of times, it was the age
+of wisdom, it was the age of foolishness, it was the epoch of belief, it
+was the epoch of incredulity, it was the season of Light, it was the
+season of Darkness, it was the spring of hope, it was the winter of
+despair, we had everything before us, we had nothing before us, we were
+all going direct to Heaven, we were all going direct the other way - in
+short, the period was so far like the present period, that some of its
+noisiest authorities insisted on its being received, for good or for
+evil, in the superlative degree of comparison only.`
+
+func TestCaesar(t *testing.T) {
+	msg := []byte("Attack at Dawn!")
+	ctxt := CaesarEncrypt(msg, 3)
+	if string(ctxt) != "Dwwdfn dw Gdzq!" {
+		t.Fatalf("Wrong ciphertext %s", ctxt)
+	}
+	if string(CaesarDecrypt(ctxt, 3)) != string(msg) {
+		t.Fatalf("Can't round trip")
+	}
+
+	shift, got, _ := BreakCaesar(CaesarEncrypt([]byte(sample), 11), cpals.EnglishScorer)
+	if shift != 11 || string(got) != sample {
+		t.Fatalf("Got shift %d msg %s", shift, got)
+	}
+}
+
+func TestVigenere(t *testing.T) {
+	ctxt, err := VigenereEncrypt([]byte("ATTACK AT DAWN"), []byte("LEMON"))
+	if err != nil {
+		t.Fatalf("Can't encrypt: %s", err)
+	}
+	if string(ctxt) != "LXFOPV EF RNHR" {
+		t.Fatalf("Wrong ciphertext %s", ctxt)
+	}
+	_, err = VigenereEncrypt(ctxt, []byte("NOT A KEY"))
+	if err == nil {
+		t.Fatalf("Didn't error on bad key")
+	}
+
+	key := []byte("DICKENS")
+	ctxt, err = VigenereEncrypt([]byte(sample), key)
+	if err != nil {
+		t.Fatalf("Can't encrypt: %s", err)
+	}
+	gotKey, got, err := BreakVigenere(ctxt, VigenereOpts{})
+	if err != nil {
+		t.Fatalf("Can't break: %s", err)
+	}
+	if string(gotKey) != string(key) || string(got) != sample {
+		t.Fatalf("Got key %s msg %s", gotKey, got)
+	}
+}
+
+func TestAffine(t *testing.T) {
+	_, err := AffineEncrypt([]byte("hello"), 13, 1)
+	if err == nil {
+		t.Fatalf("Didn't error on bad multiplier")
+	}
+	ctxt, err := AffineEncrypt([]byte(sample), 7, 12)
+	if err != nil {
+		t.Fatalf("Can't encrypt: %s", err)
+	}
+	a, b, got := BreakAffine(ctxt, cpals.EnglishScorer)
+	if a != 7 || b != 12 || string(got) != sample {
+		t.Fatalf("Got key (%d, %d) msg %s", a, b, got)
+	}
+}
+
+func TestSubstitution(t *testing.T) {
+	key := []byte("QWERTYUIOPASDFGHJKLZXCVBNM")
+	ctxt, err := SubstitutionEncrypt([]byte(sample), key)
+	if err != nil {
+		t.Fatalf("Can't encrypt: %s", err)
+	}
+	msg, err := SubstitutionDecrypt(ctxt, key)
+	if err != nil || string(msg) != sample {
+		t.Fatalf("Can't round trip: %s", err)
+	}
+	_, err = SubstitutionEncrypt(ctxt, []byte("QWERTYUIOPASDFGHJKLZXCVBNQ"))
+	if err == nil {
+		t.Fatalf("Didn't error on repeated key letter")
+	}
+
+	// Hamlet alone is too little English to train on
+	corpus := append([]byte{}, cpals.Hamlet...)
+	corpus = append(corpus, cpals.AESECBDecrypt(cpals.YellowKey, cpals.MustLoadB64("../7.txt"))...)
+	scorer := cpals.LogLikelihoodScorer{NGramModel: cpals.TrainNGramModel(3, corpus)}
+	gotKey, got, err := BreakSubstitution(ctxt, SubstitutionOpts{Scorer: scorer})
+	if err != nil {
+		t.Fatalf("Can't break: %s", err)
+	}
+	// Rare letters may not appear in the sample, so check the plaintext
+	// rather than the key
+	wrong := 0
+	for i := range got {
+		if got[i] != sample[i] {
+			wrong++
+		}
+	}
+	if wrong > len(sample)/50 {
+		t.Fatalf("Got key %s with %d wrong bytes: %s", gotKey, wrong, got)
+	}
+	t.Logf("Got key %s: %s", gotKey, got)
+}
@@ -0,0 +1,136 @@
+// Package cmac implements CMAC (OMAC1) as defined for AES in RFC 4493.
+//
+// CMAC fixes CBC-MAC for variable length messages by XORing one of two
+// subkeys in to the last block, depending on whether it needed padding.
+package cmac
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+
+	"github.com/jbert/cpals-go/hash"
+)
+
+// CMAC is a hash.Hash, keyed by its block cipher
+type CMAC struct {
+	b      cipher.Block
+	k1, k2 []byte
+	// x is the CBC state over the blocks before buf
+	x []byte
+	// buf holds the last block, which can't be processed until we know it
+	// is the last
+	buf []byte
+}
+
+var _ hash.Hash = &CMAC{}
+
+// New creates a CMAC with any 64 or 128 bit block cipher
+func New(b cipher.Block) (*CMAC, error) {
+	bs := b.BlockSize()
+	if bs != 8 && bs != 16 {
+		return nil, fmt.Errorf("CMAC needs a 64 or 128 bit block, not %d bytes", bs)
+	}
+	l := make([]byte, bs)
+	b.Encrypt(l, l)
+	k1 := double(l)
+	k2 := double(k1)
+	c := &CMAC{b: b, k1: k1, k2: k2}
+	c.Reset()
+	return c, nil
+}
+
+// NewAES creates AES-CMAC
+func NewAES(key []byte) (*CMAC, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Can't create aes cipher: %w", err)
+	}
+	return New(b)
+}
+
+// Subkeys returns K1 and K2
+func (c *CMAC) Subkeys() ([]byte, []byte) {
+	return append([]byte{}, c.k1...), append([]byte{}, c.k2...)
+}
+
+// double multiplies by x in GF(2^n), shifting left and reducing by
+// x^128 + x^7 + x^2 + x + 1 (or x^64 + x^4 + x^3 + x + 1)
+func double(in []byte) []byte {
+	out := make([]byte, len(in))
+	for i := 0; i < len(in)-1; i++ {
+		out[i] = in[i]<<1 | in[i+1]>>7
+	}
+	out[len(in)-1] = in[len(in)-1] << 1
+	if in[0]&0x80 != 0 {
+		if len(in) == 16 {
+			out[len(in)-1] ^= 0x87
+		} else {
+			out[len(in)-1] ^= 0x1b
+		}
+	}
+	return out
+}
+
+func (c *CMAC) Size() int {
+	return c.b.BlockSize()
+}
+
+func (c *CMAC) BlockSize() int {
+	return c.b.BlockSize()
+}
+
+func (c *CMAC) Reset() {
+	c.x = make([]byte, c.b.BlockSize())
+	c.buf = c.buf[:0]
+}
+
+func (c *CMAC) Write(p []byte) (int, error) {
+	bs := c.b.BlockSize()
+	n := len(p)
+	for len(p) > 0 {
+		// Only process a full buf once there is more to come
+		if len(c.buf) == bs {
+			for i := range c.x {
+				c.x[i] ^= c.buf[i]
+			}
+			c.b.Encrypt(c.x, c.x)
+			c.buf = c.buf[:0]
+		}
+		used := bs - len(c.buf)
+		if used > len(p) {
+			used = len(p)
+		}
+		c.buf = append(c.buf, p[:used]...)
+		p = p[used:]
+	}
+	return n, nil
+}
+
+func (c *CMAC) MustWrite(p []byte) {
+	n, err := c.Write(p)
+	if n != len(p) {
+		err = fmt.Errorf("Wrote %d bytes to hash, not %d", n, len(p))
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Can't write to hash: %s", err))
+	}
+}
+
+// Sum appends the tag to in. A whole last block is XORed with K1, a
+// partial one is padded with 0x80 then zeros and XORed with K2.
+func (c *CMAC) Sum(in []byte) []byte {
+	bs := c.b.BlockSize()
+	last := make([]byte, bs)
+	copy(last, c.buf)
+	k := c.k1
+	if len(c.buf) < bs {
+		last[len(c.buf)] = 0x80
+		k = c.k2
+	}
+	for i := range last {
+		last[i] ^= k[i] ^ c.x[i]
+	}
+	c.b.Encrypt(last, last)
+	return append(in, last...)
+}
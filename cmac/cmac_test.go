@@ -0,0 +1,83 @@
+package cmac
+
+import (
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+// RFC 4493 section 4
+const (
+	rfcKey = "2b7e151628aed2a6abf7158809cf4f3c"
+	rfcMsg = "6bc1bee22e409f96e93d7e117393172a" +
+		"ae2d8a571e03ac9c9eb76fac45af8e51" +
+		"30c81c46a35ce411e5fbc1191a0a52ef" +
+		"f69f2445df4f9b17ad2b417be66c3710"
+)
+
+func mustDeHex(t *testing.T, h string) []byte {
+	buf, err := hex.DecodeString(h)
+	if err != nil {
+		t.Fatalf("Bad hex [%s]: %s", h, err)
+	}
+	return buf
+}
+
+func TestSubkeys(t *testing.T) {
+	c, err := NewAES(mustDeHex(t, rfcKey))
+	if err != nil {
+		t.Fatalf("Can't create CMAC: %s", err)
+	}
+	k1, k2 := c.Subkeys()
+	if hex.EncodeToString(k1) != "fbeed618357133667c85e08f7236a8de" {
+		t.Fatalf("Bad K1 %x", k1)
+	}
+	if hex.EncodeToString(k2) != "f7ddac306ae266ccf90bc11ee46d513b" {
+		t.Fatalf("Bad K2 %x", k2)
+	}
+}
+
+func TestKnownAnswers(t *testing.T) {
+	c, _ := NewAES(mustDeHex(t, rfcKey))
+	msg := mustDeHex(t, rfcMsg)
+	testCases := []struct {
+		msgLen   int
+		expected string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	}
+	for _, tc := range testCases {
+		c.Reset()
+		// Odd sized writes, to check the last block is held back
+		for i := 0; i < tc.msgLen; i += 5 {
+			end := i + 5
+			if end > tc.msgLen {
+				end = tc.msgLen
+			}
+			c.MustWrite(msg[i:end])
+		}
+		got := hex.EncodeToString(c.Sum(nil))
+		if got != tc.expected {
+			t.Fatalf("%d: got %s expected %s", tc.msgLen, got, tc.expected)
+		}
+		// Sum doesn't change the state
+		if hex.EncodeToString(c.Sum(nil)) != got {
+			t.Fatalf("%d: Sum changed the state", tc.msgLen)
+		}
+	}
+}
+
+func TestBadBlockSize(t *testing.T) {
+	_, err := NewAES([]byte("short"))
+	if err == nil {
+		t.Fatalf("Didn't error on bad key")
+	}
+	b, _ := aes.NewCipher(mustDeHex(t, rfcKey))
+	c, err := New(b)
+	if err != nil || c.Size() != 16 || c.BlockSize() != 16 {
+		t.Fatalf("Bad sizes: %s", err)
+	}
+}
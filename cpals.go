@@ -7,10 +7,10 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
 	"math/bits"
 	"math/rand"
 	"net/url"
-	"sort"
 	"strings"
 	"time"
 
@@ -83,12 +83,16 @@ func Xor(a, b []byte) ([]byte, error) {
 }
 
 func SolveEnglishSingleByteXor(ctxt []byte) ([]byte, float64, byte) {
+	return SolveSingleByteXor(ctxt, EnglishScorer)
+}
+
+func SolveSingleByteXor(ctxt []byte, scorer Scorer) ([]byte, float64, byte) {
 	var bestB byte
-	bestScore := 0.0
+	bestScore := math.Inf(-1)
 	for bi := 0; bi <= 0xff; bi++ {
 		b := byte(bi)
 		buf := XorByte(ctxt, b)
-		score := EnglishScore(buf)
+		score := scorer.Score(buf)
 		//fmt.Printf("%02X: %f: %s\n", bi, score, buf)
 		if score > bestScore {
 			bestB = b
@@ -193,43 +197,30 @@ func byteHammingDist(a, b byte) int {
 	return bits.OnesCount8(x)
 }
 
-func GuessXorKeySize(buf []byte) []int {
-
-	type sizeDistance struct {
-		keySize  int
-		distance float64
-	}
-	sizeDistances := make([]sizeDistance, 0)
-
-	for keySize := 2; keySize < 41; keySize++ {
-		chunks, _ := BytesToChunks(buf, keySize)
-
-		if len(chunks) < 4 {
-			panic("madness")
+// GuessXorKeySize ranks key sizes, most likely first, by the normalised
+// Hamming distance between chunks, averaged over every pair of chunks.
+func GuessXorKeySize(buf []byte, minKeySize, maxKeySize int) ([]int, error) {
+	return RankKeyLengths(HammingEstimator{}, buf, minKeySize, maxKeySize)
+}
+
+// meanPairwiseHammingDistance averages HammingDistance over all pairs of
+// (equal length) chunks. Rather than comparing every pair, for each bit
+// we count the chunks with it set - each of those differs from each chunk
+// with it clear.
+func meanPairwiseHammingDistance(chunks [][]byte) float64 {
+	n := len(chunks)
+	total := 0
+	for pos := range chunks[0] {
+		for bit := uint(0); bit < 8; bit++ {
+			set := 0
+			for _, c := range chunks {
+				set += int(c[pos]>>bit) & 1
+			}
+			total += set * (n - set)
 		}
-		distance := 0.0
-		chunkDistance, _ := HammingDistance(chunks[0], chunks[1])
-		distance += float64(chunkDistance)
-		chunkDistance, _ = HammingDistance(chunks[1], chunks[2])
-		distance += float64(chunkDistance)
-		chunkDistance, _ = HammingDistance(chunks[2], chunks[3])
-		distance += float64(chunkDistance)
-
-		distance /= 3.0
-		distance /= float64(keySize)
-
-		sizeDistances = append(sizeDistances, sizeDistance{keySize, distance})
-	}
-
-	sort.Slice(sizeDistances, func(i, j int) bool {
-		return sizeDistances[i].distance < sizeDistances[j].distance
-	})
-
-	sortedSizes := make([]int, len(sizeDistances))
-	for i := range sizeDistances {
-		sortedSizes[i] = sizeDistances[i].keySize
 	}
-	return sortedSizes
+	numPairs := n * (n - 1) / 2
+	return float64(total) / float64(numPairs)
 }
 
 func ChunksEqual(a, b [][]byte) bool {
@@ -256,73 +247,132 @@ func ChunksTranspose(in [][]byte) [][]byte {
 	return out
 }
 
-type ECBDecrypter struct {
-	cipher.Block
+type ecbDecrypter struct {
+	b cipher.Block
 }
 
-func NewECBDecrypter(cipher cipher.Block) ECBDecrypter {
-	return ECBDecrypter{cipher}
+// NewECBDecrypter returns a BlockMode which decrypts each block
+// independently
+func NewECBDecrypter(b cipher.Block) cipher.BlockMode {
+	return &ecbDecrypter{b}
 }
 
-// Implent crypto/cipher.BlockMode
-func (d *ECBDecrypter) BlockSize() int {
-	return d.Block.BlockSize()
+func (d *ecbDecrypter) BlockSize() int {
+	return d.b.BlockSize()
 }
 
-func (d *ECBDecrypter) CryptBlocks(dst, src []byte) {
-	nBlocks := len(src) / d.BlockSize()
-	for i := 0; i < nBlocks; i++ {
-		d.Decrypt(dst[i*d.BlockSize():], src[i*d.BlockSize():])
+func (d *ecbDecrypter) CryptBlocks(dst, src []byte) {
+	checkBlocks(d.BlockSize(), dst, src)
+	for i := 0; i < len(src); i += d.BlockSize() {
+		d.b.Decrypt(dst[i:], src[i:])
 	}
 }
 
-type ECBEncrypter struct {
-	cipher.Block
+type ecbEncrypter struct {
+	b cipher.Block
 }
 
-func NewECBEncrypter(cipher cipher.Block) ECBEncrypter {
-	return ECBEncrypter{cipher}
+// NewECBEncrypter returns a BlockMode which encrypts each block
+// independently
+func NewECBEncrypter(b cipher.Block) cipher.BlockMode {
+	return &ecbEncrypter{b}
 }
 
-// Implent crypto/cipher.BlockMode
-func (d *ECBEncrypter) BlockSize() int {
-	return d.Block.BlockSize()
+func (e *ecbEncrypter) BlockSize() int {
+	return e.b.BlockSize()
 }
 
-func (d *ECBEncrypter) CryptBlocks(dst, src []byte) {
-	nBlocks := len(src) / d.BlockSize()
-	for i := 0; i < nBlocks; i++ {
-		d.Encrypt(dst[i*d.BlockSize():], src[i*d.BlockSize():])
+func (e *ecbEncrypter) CryptBlocks(dst, src []byte) {
+	checkBlocks(e.BlockSize(), dst, src)
+	for i := 0; i < len(src); i += e.BlockSize() {
+		e.b.Encrypt(dst[i:], src[i:])
 	}
 }
 
+// checkBlocks panics on bad CryptBlocks arguments, as the crypto/cipher
+// modes do
+func checkBlocks(blockSize int, dst, src []byte) {
+	if len(src)%blockSize != 0 {
+		panic("crypto/cipher: input not full blocks")
+	}
+	if len(dst) < len(src) {
+		panic("crypto/cipher: output smaller than input")
+	}
+}
+
+// checkFullBlocks is checkBlocks for callers who want an error
+func checkFullBlocks(blockSize int, buf []byte) error {
+	if len(buf)%blockSize != 0 {
+		return fmt.Errorf("Buffer len %d not a multiple of block size %d", len(buf), blockSize)
+	}
+	return nil
+}
+
 func AESECBDecrypt(key []byte, buf []byte) []byte {
+	dst, err := AESECBDecryptErr(key, buf)
+	if err != nil {
+		panic(err.Error())
+	}
+	return dst
+}
+
+func AESECBDecryptErr(key []byte, buf []byte) ([]byte, error) {
+	return AESECBDecryptWith(key, buf, PKCS7Padder{})
+}
+
+// AESECBDecryptWith decrypts and unpads with padder. A nil padder leaves
+// the padding in place.
+func AESECBDecryptWith(key []byte, buf []byte, padder Padder) ([]byte, error) {
 	aes, err := aes.NewCipher(key)
 	if err != nil {
-		panic(fmt.Sprintf("Can't create aes cipher: %s", err))
+		return nil, fmt.Errorf("Can't create aes cipher: %w", err)
+	}
+	if err := checkFullBlocks(aes.BlockSize(), buf); err != nil {
+		return nil, fmt.Errorf("Can't decrypt: %w", err)
 	}
 	dec := NewECBDecrypter(aes)
 
 	dst := make([]byte, len(buf))
 	dec.CryptBlocks(dst, buf)
-	dst, err = BytesPKCS7UnPad(dst)
+	if padder != nil {
+		dst, err = padder.Unpad(dst, AESBlockSize)
+		if err != nil {
+			return nil, fmt.Errorf("Can't decrypt - invalid padding: %w", err)
+		}
+	}
+	return dst, nil
+}
+
+func AESECBEncrypt(key []byte, buf []byte) []byte {
+	dst, err := AESECBEncryptErr(key, buf)
 	if err != nil {
-		panic(fmt.Sprintf("Can't decrypt - invalid padding: %s", err))
+		panic(err.Error())
 	}
 	return dst
 }
 
-func AESECBEncrypt(key []byte, buf []byte) []byte {
-	buf = BytesPKCS7Pad(buf, AESBlockSize)
+func AESECBEncryptErr(key []byte, buf []byte) ([]byte, error) {
+	return AESECBEncryptWith(key, buf, PKCS7Padder{})
+}
+
+// AESECBEncryptWith pads with padder and encrypts. A nil padder requires
+// buf to be whole blocks.
+func AESECBEncryptWith(key []byte, buf []byte, padder Padder) ([]byte, error) {
+	if padder != nil {
+		buf = padder.Pad(buf, AESBlockSize)
+	}
 	aes, err := aes.NewCipher(key)
 	if err != nil {
-		panic(fmt.Sprintf("Can't create aes cipher: %s", err))
+		return nil, fmt.Errorf("Can't create aes cipher: %w", err)
+	}
+	if err := checkFullBlocks(aes.BlockSize(), buf); err != nil {
+		return nil, fmt.Errorf("Can't encrypt: %w", err)
 	}
-	dec := NewECBEncrypter(aes)
+	enc := NewECBEncrypter(aes)
 
 	dst := make([]byte, len(buf))
-	dec.CryptBlocks(dst, buf)
-	return dst
+	enc.CryptBlocks(dst, buf)
+	return dst, nil
 }
 
 func BytesFindDuplicateBlock(buf []byte, blockSize int) []byte {
@@ -367,64 +417,75 @@ func BytesPKCS7UnPad(buf []byte) ([]byte, error) {
 	return buf[:len(buf)-padVal], nil
 }
 
-type CBCEncrypter struct {
-	cipher.Block
-	iv []byte
+// cbc holds the chaining state, which carries over between calls to
+// CryptBlocks
+type cbc struct {
+	b    cipher.Block
+	prev []byte
 }
 
-func NewCBCEncrypter(cipher cipher.Block, iv []byte) CBCEncrypter {
-	if len(iv) != cipher.BlockSize() {
-		panic(fmt.Sprintf("iv length must match blocksize %d != %d", len(iv), cipher.BlockSize()))
+func newCBC(b cipher.Block, iv []byte) (*cbc, error) {
+	prev, err := copyIV(b, iv)
+	if err != nil {
+		return nil, err
 	}
-	return CBCEncrypter{cipher, iv}
+	return &cbc{b, prev}, nil
 }
 
-func (d *CBCEncrypter) BlockSize() int {
-	return d.Block.BlockSize()
+type cbcEncrypter cbc
+
+func NewCBCEncrypter(b cipher.Block, iv []byte) (cipher.BlockMode, error) {
+	c, err := newCBC(b, iv)
+	if err != nil {
+		return nil, err
+	}
+	return (*cbcEncrypter)(c), nil
 }
 
-func (d *CBCEncrypter) CryptBlocks(dst, src []byte) {
-	nBlocks := len(src) / d.BlockSize()
-	lastCipherBlock := d.iv
-	for i := 0; i < nBlocks; i++ {
-		srcBlock := src[i*d.BlockSize() : (i+1)*d.BlockSize()]
-		srcBlock, err := Xor(srcBlock, lastCipherBlock)
-		if err != nil {
-			panic(fmt.Sprintf("block size confusion: %s", err))
+func (e *cbcEncrypter) BlockSize() int {
+	return e.b.BlockSize()
+}
+
+func (e *cbcEncrypter) CryptBlocks(dst, src []byte) {
+	bs := e.BlockSize()
+	checkBlocks(bs, dst, src)
+	for i := 0; i < len(src); i += bs {
+		block := dst[i : i+bs]
+		for j := range block {
+			block[j] = src[i+j] ^ e.prev[j]
 		}
-		d.Encrypt(dst[i*d.BlockSize():], srcBlock)
-		lastCipherBlock = dst[i*d.BlockSize() : (i+1)*d.BlockSize()]
+		e.b.Encrypt(block, block)
+		copy(e.prev, block)
 	}
 }
 
-type CBCDecrypter struct {
-	cipher.Block
-	iv []byte
-}
+type cbcDecrypter cbc
 
-func NewCBCDecrypter(cipher cipher.Block, iv []byte) CBCDecrypter {
-	if len(iv) != cipher.BlockSize() {
-		panic(fmt.Sprintf("iv length must match blocksize %d != %d", len(iv), cipher.BlockSize()))
+func NewCBCDecrypter(b cipher.Block, iv []byte) (cipher.BlockMode, error) {
+	c, err := newCBC(b, iv)
+	if err != nil {
+		return nil, err
 	}
-	return CBCDecrypter{cipher, iv}
+	return (*cbcDecrypter)(c), nil
 }
 
-func (d *CBCDecrypter) BlockSize() int {
-	return d.Block.BlockSize()
+func (d *cbcDecrypter) BlockSize() int {
+	return d.b.BlockSize()
 }
 
-func (d *CBCDecrypter) CryptBlocks(dst, src []byte) {
-	nBlocks := len(src) / d.BlockSize()
-	lastCipherBlock := d.iv
-	workBlock := make([]byte, d.BlockSize())
-	for i := 0; i < nBlocks; i++ {
-		d.Decrypt(workBlock, src[i*d.BlockSize():])
-		workBlock, err := Xor(workBlock, lastCipherBlock)
-		if err != nil {
-			panic(fmt.Sprintf("block size confusion: %s", err))
+func (d *cbcDecrypter) CryptBlocks(dst, src []byte) {
+	bs := d.BlockSize()
+	checkBlocks(bs, dst, src)
+	// Keep the ciphertext block, since dst may be src
+	ctxtBlock := make([]byte, bs)
+	for i := 0; i < len(src); i += bs {
+		copy(ctxtBlock, src[i:i+bs])
+		block := dst[i : i+bs]
+		d.b.Decrypt(block, ctxtBlock)
+		for j := range block {
+			block[j] ^= d.prev[j]
 		}
-		copy(dst[i*d.BlockSize():], workBlock)
-		lastCipherBlock = src[i*d.BlockSize() : (i+1)*d.BlockSize()]
+		copy(d.prev, ctxtBlock)
 	}
 }
 
@@ -432,35 +493,85 @@ func AESCBCDecrypt(key []byte, iv []byte, buf []byte) []byte {
 	return AESCBCDecryptMaybePadding(key, iv, buf, true)
 }
 
+func AESCBCDecryptErr(key []byte, iv []byte, buf []byte) ([]byte, error) {
+	return AESCBCDecryptWith(key, iv, buf, PKCS7Padder{})
+}
+
 func AESCBCDecryptMaybePadding(key []byte, iv []byte, buf []byte, unpad bool) []byte {
+	dst, err := AESCBCDecryptMaybePaddingErr(key, iv, buf, unpad)
+	if err != nil {
+		panic(err.Error())
+	}
+	return dst
+}
+
+func AESCBCDecryptMaybePaddingErr(key []byte, iv []byte, buf []byte, unpad bool) ([]byte, error) {
+	var padder Padder
+	if unpad {
+		padder = PKCS7Padder{}
+	}
+	return AESCBCDecryptWith(key, iv, buf, padder)
+}
+
+// AESCBCDecryptWith decrypts and unpads with padder. A nil padder leaves
+// the padding in place.
+func AESCBCDecryptWith(key []byte, iv []byte, buf []byte, padder Padder) ([]byte, error) {
 	aes, err := aes.NewCipher(key)
 	if err != nil {
-		panic(fmt.Sprintf("Can't create aes cipher: %s", err))
+		return nil, fmt.Errorf("Can't create aes cipher: %w", err)
+	}
+	if err := checkFullBlocks(aes.BlockSize(), buf); err != nil {
+		return nil, fmt.Errorf("Can't decrypt: %w", err)
+	}
+	dec, err := NewCBCDecrypter(aes, iv)
+	if err != nil {
+		return nil, fmt.Errorf("Can't create decrypter: %w", err)
 	}
-	dec := NewCBCDecrypter(aes, iv)
 
 	dst := make([]byte, len(buf))
 	dec.CryptBlocks(dst, buf)
-	if unpad {
-		dst, err = BytesPKCS7UnPad(dst)
+	if padder != nil {
+		dst, err = padder.Unpad(dst, AESBlockSize)
 		if err != nil {
-			panic(fmt.Sprintf("Can't decrypt - invalid padding: %s", err))
+			return nil, fmt.Errorf("Can't decrypt - invalid padding: %w", err)
 		}
 	}
-	return dst
+	return dst, nil
 }
 
 func AESCBCEncrypt(key []byte, iv []byte, buf []byte) []byte {
-	buf = BytesPKCS7Pad(buf, AESBlockSize)
+	dst, err := AESCBCEncryptErr(key, iv, buf)
+	if err != nil {
+		panic(err.Error())
+	}
+	return dst
+}
+
+func AESCBCEncryptErr(key []byte, iv []byte, buf []byte) ([]byte, error) {
+	return AESCBCEncryptWith(key, iv, buf, PKCS7Padder{})
+}
+
+// AESCBCEncryptWith pads with padder and encrypts. A nil padder requires
+// buf to be whole blocks.
+func AESCBCEncryptWith(key []byte, iv []byte, buf []byte, padder Padder) ([]byte, error) {
+	if padder != nil {
+		buf = padder.Pad(buf, AESBlockSize)
+	}
 	aes, err := aes.NewCipher(key)
 	if err != nil {
-		panic(fmt.Sprintf("Can't create aes cipher: %s", err))
+		return nil, fmt.Errorf("Can't create aes cipher: %w", err)
+	}
+	if err := checkFullBlocks(aes.BlockSize(), buf); err != nil {
+		return nil, fmt.Errorf("Can't encrypt: %w", err)
+	}
+	enc, err := NewCBCEncrypter(aes, iv)
+	if err != nil {
+		return nil, fmt.Errorf("Can't create encrypter: %w", err)
 	}
-	dec := NewCBCEncrypter(aes, iv)
 
 	dst := make([]byte, len(buf))
-	dec.CryptBlocks(dst, buf)
-	return dst
+	enc.CryptBlocks(dst, buf)
+	return dst, nil
 }
 
 func RandomRandomBytes(lo, hi int) []byte {
@@ -484,7 +595,10 @@ func RandomKey() []byte {
 	return RandomBytes(len(YellowKey))
 }
 
-func FindBlockSizeAndFullPadBlock(blockCryptor func(buf []byte) []byte) (int, []byte) {
+// FindBlockSizeAndFullPadBlock feeds blockCryptor longer and longer
+// messages until the ciphertext grows. The growth is the block size, and
+// the last block is then all padding.
+func FindBlockSizeAndFullPadBlock(blockCryptor func(buf []byte) []byte) (int, []byte, error) {
 	lastLen := 0
 	maxBlockSize := 1024
 	for i := 0; i < maxBlockSize; i++ {
@@ -492,7 +606,7 @@ func FindBlockSizeAndFullPadBlock(blockCryptor func(buf []byte) []byte) (int, []
 		buf := blockCryptor(in)
 		l := len(buf)
 		if l == 0 {
-			panic("not a block cipher")
+			return 0, nil, errors.New("Not a block cipher: empty ciphertext")
 		}
 		if lastLen == 0 {
 			lastLen = l
@@ -500,10 +614,13 @@ func FindBlockSizeAndFullPadBlock(blockCryptor func(buf []byte) []byte) (int, []
 		}
 		if lastLen != l {
 			blockSize := l - lastLen
-			return blockSize, buf[len(buf)-blockSize:]
+			if blockSize < 0 {
+				return 0, nil, fmt.Errorf("Ciphertext shrank from %d to %d bytes", lastLen, l)
+			}
+			return blockSize, buf[len(buf)-blockSize:], nil
 		}
 	}
-	panic("Didn't find blocksize")
+	return 0, nil, fmt.Errorf("Ciphertext didn't grow in %d bytes", maxBlockSize)
 }
 
 func HasDuplicateBlocks(buf []byte, blockSize int) bool {
@@ -569,27 +686,20 @@ func ParseProfile(s string) (UserProfile, error) {
 }
 
 func AESCTRKeyStream(key []byte, nonce int64, bufLen int) []byte {
-	ret := make([]byte, bufLen)
-	blockCount := int64(0)
-	for i := 0; i < bufLen; i += len(key) {
-		w := &bytes.Buffer{}
-		//		fmt.Printf("i %d blockCount %d\n", i, blockCount)
-		binary.Write(w, binary.LittleEndian, nonce)
-		binary.Write(w, binary.LittleEndian, blockCount)
-		//		fmt.Printf("AES: %s\n", EnHex(w.Bytes()))
-		buf := AESECBEncrypt(key, w.Bytes())
-		copy(ret[i:], buf) // If ret is short of space, only copies needed
-		blockCount++
+	ctr, err := NewAESCTR(key, nonce)
+	if err != nil {
+		panic(err.Error())
 	}
-	return ret
+	return ctr.KeyStream(bufLen)
 }
 
 func AESCTR(key []byte, nonce int64, in []byte) []byte {
-	keystream := AESCTRKeyStream(key, nonce, len(in))
-	buf, err := Xor(in, keystream)
+	ctr, err := NewAESCTR(key, nonce)
 	if err != nil {
-		panic(fmt.Sprintf("Internal error AESCTR Xor : %s", err))
+		panic(err.Error())
 	}
+	buf := make([]byte, len(in))
+	ctr.XORKeyStream(buf, in)
 	return buf
 }
 
@@ -1,6 +1,8 @@
 package cpals
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"fmt"
 	"testing"
 )
@@ -115,6 +117,81 @@ func TestAESECB(t *testing.T) {
 	t.Log("Can encrypt and decrypt AES ECB")
 }
 
+func TestCBCBlockMode(t *testing.T) {
+	key := RandomBytes(AESBlockSize)
+	iv := RandomBytes(AESBlockSize)
+	msg := RandomBytes(4 * AESBlockSize)
+	block, _ := aes.NewCipher(key)
+
+	expected := make([]byte, len(msg))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(expected, msg)
+
+	// Chaining carries over between calls, and works in place
+	enc, err := NewCBCEncrypter(block, iv)
+	if err != nil {
+		t.Fatalf("Can't create encrypter: %s", err)
+	}
+	got := make([]byte, len(msg))
+	copy(got, msg)
+	enc.CryptBlocks(got[:AESBlockSize], got[:AESBlockSize])
+	enc.CryptBlocks(got[AESBlockSize:], got[AESBlockSize:])
+	if !BytesEqual(got, expected) {
+		t.Fatalf("Got %s expected %s", EnHex(got), EnHex(expected))
+	}
+
+	dec, err := NewCBCDecrypter(block, iv)
+	if err != nil {
+		t.Fatalf("Can't create decrypter: %s", err)
+	}
+	dec.CryptBlocks(got[:3*AESBlockSize], got[:3*AESBlockSize])
+	dec.CryptBlocks(got[3*AESBlockSize:], got[3*AESBlockSize:])
+	if !BytesEqual(got, msg) {
+		t.Fatalf("Got %s expected %s", EnHex(got), EnHex(msg))
+	}
+
+	_, err = NewCBCEncrypter(block, iv[1:])
+	if err == nil {
+		t.Fatalf("Didn't error on short iv")
+	}
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatalf("Didn't panic on partial block")
+		}
+		t.Logf("Partial block panicked ok: %v", r)
+	}()
+	NewECBEncrypter(block).CryptBlocks(got, got[1:])
+}
+
+func TestAESErr(t *testing.T) {
+	_, err := AESECBEncryptErr([]byte("short key"), []byte("hello"))
+	if err == nil {
+		t.Fatalf("Didn't error on bad key")
+	}
+	t.Logf("Bad key errored ok: %s", err)
+
+	key := RandomBytes(AESBlockSize)
+	iv := RandomBytes(AESBlockSize)
+	buf, err := AESCBCEncryptErr(key, iv, []byte("hello"))
+	if err != nil {
+		t.Fatalf("Can't encrypt: %s", err)
+	}
+	_, err = AESCBCDecryptErr(key, iv, buf[:len(buf)-1])
+	if err == nil {
+		t.Fatalf("Didn't error on partial block")
+	}
+	// Flipping a bit of the last pad byte (via the IV) gives bad padding
+	badIV := make([]byte, len(iv))
+	copy(badIV, iv)
+	badIV[AESBlockSize-1] ^= 0x10
+	_, err = AESCBCDecryptErr(key, badIV, buf)
+	if err == nil {
+		t.Fatalf("Didn't error on bad padding")
+	}
+	t.Logf("Bad padding errored ok: %s", err)
+}
+
 func TestChunksTranspose(t *testing.T) {
 	testCases := []struct {
 		in       [][]byte
@@ -165,6 +242,24 @@ func TestBytesToChunks(t *testing.T) {
 	}
 }
 
+func TestMinimalPeriod(t *testing.T) {
+	testCases := []struct {
+		in       string
+		expected string
+	}{
+		{"ICEICE", "ICE"},
+		{"ICEICF", "ICEICF"},
+		{"AAAA", "A"},
+		{"A", "A"},
+	}
+	for _, tc := range testCases {
+		got := MinimalPeriod([]byte(tc.in))
+		if string(got) != tc.expected {
+			t.Errorf("%s: got %s expected %s", tc.in, got, tc.expected)
+		}
+	}
+}
+
 func TestHammingDistance(t *testing.T) {
 	a := []byte("this is a test")
 	b := []byte("wokka wokka!!!")
@@ -0,0 +1,250 @@
+package cpals
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// CribSession recovers a keystream shared by several ciphertexts (a
+// two-time pad, or CTR with a reused nonce) by pinning guessed plaintext
+// ("cribs") and seeing what that implies for the other messages.
+type CribSession struct {
+	ctxts     [][]byte
+	keyStream []byte
+	known     []bool
+	history   []cribEdit
+}
+
+// cribEdit records the keystream state overwritten by an edit, so it can
+// be undone
+type cribEdit struct {
+	offset    int
+	keyStream []byte
+	known     []bool
+}
+
+// CribFit describes what placing a crib would imply
+type CribFit struct {
+	Msg    int
+	Offset int
+	// Mean score of the plaintext fragments implied in the other messages
+	Score float64
+	// Every implied fragment is printable ASCII
+	Printable bool
+	// Number of already-known keystream bytes the crib disagrees with
+	Conflicts int
+}
+
+// UnknownByte stands in for plaintext we don't have keystream for
+const UnknownByte = '_'
+
+func NewCribSession(ctxts [][]byte) (*CribSession, error) {
+	if len(ctxts) == 0 {
+		return nil, errors.New("No ciphertexts")
+	}
+	maxLen := 0
+	for _, ct := range ctxts {
+		if len(ct) > maxLen {
+			maxLen = len(ct)
+		}
+	}
+	return &CribSession{
+		ctxts:     ctxts,
+		keyStream: make([]byte, maxLen),
+		known:     make([]bool, maxLen),
+	}, nil
+}
+
+func (s *CribSession) checkCrib(msg, offset int, crib []byte) error {
+	if msg < 0 || msg >= len(s.ctxts) {
+		return fmt.Errorf("No message %d", msg)
+	}
+	if offset < 0 || offset+len(crib) > len(s.ctxts[msg]) {
+		return fmt.Errorf("Crib len %d at offset %d doesn't fit message %d (len %d)", len(crib), offset, msg, len(s.ctxts[msg]))
+	}
+	return nil
+}
+
+// Place pins crib as the plaintext of message msg at offset, updating the
+// keystream for every message.
+func (s *CribSession) Place(msg, offset int, crib []byte) error {
+	if err := s.checkCrib(msg, offset, crib); err != nil {
+		return err
+	}
+	keyStream := make([]byte, len(crib))
+	for i := range crib {
+		keyStream[i] = s.ctxts[msg][offset+i] ^ crib[i]
+	}
+	s.setKeyStream(offset, keyStream, nil)
+	return nil
+}
+
+// SetKeyStream pins keystream bytes directly
+func (s *CribSession) SetKeyStream(offset int, keyStream []byte) error {
+	if offset < 0 || offset+len(keyStream) > len(s.keyStream) {
+		return fmt.Errorf("Keystream len %d at offset %d doesn't fit len %d", len(keyStream), offset, len(s.keyStream))
+	}
+	s.setKeyStream(offset, keyStream, nil)
+	return nil
+}
+
+// setKeyStream records an undoable edit. If mask is non-nil, only
+// positions where it is true are changed.
+func (s *CribSession) setKeyStream(offset int, keyStream []byte, mask []bool) {
+	edit := cribEdit{
+		offset:    offset,
+		keyStream: make([]byte, len(keyStream)),
+		known:     make([]bool, len(keyStream)),
+	}
+	copy(edit.keyStream, s.keyStream[offset:])
+	copy(edit.known, s.known[offset:])
+	s.history = append(s.history, edit)
+
+	for i, k := range keyStream {
+		if mask != nil && !mask[i] {
+			continue
+		}
+		s.keyStream[offset+i] = k
+		s.known[offset+i] = true
+	}
+}
+
+// Undo reverts the most recent edit
+func (s *CribSession) Undo() error {
+	if len(s.history) == 0 {
+		return errors.New("Nothing to undo")
+	}
+	edit := s.history[len(s.history)-1]
+	s.history = s.history[:len(s.history)-1]
+	copy(s.keyStream[edit.offset:], edit.keyStream)
+	copy(s.known[edit.offset:], edit.known)
+	return nil
+}
+
+// Fit reports what placing crib would imply, without placing it
+func (s *CribSession) Fit(msg, offset int, crib []byte, scorer Scorer) (CribFit, error) {
+	fit := CribFit{Msg: msg, Offset: offset, Printable: true}
+	if err := s.checkCrib(msg, offset, crib); err != nil {
+		return fit, err
+	}
+	keyStream := make([]byte, len(crib))
+	for i := range crib {
+		keyStream[i] = s.ctxts[msg][offset+i] ^ crib[i]
+		if s.known[offset+i] && s.keyStream[offset+i] != keyStream[i] {
+			fit.Conflicts++
+		}
+	}
+
+	total := 0.0
+	numFragments := 0
+	for j, ct := range s.ctxts {
+		if j == msg || len(ct) <= offset {
+			continue
+		}
+		end := offset + len(crib)
+		if end > len(ct) {
+			end = len(ct)
+		}
+		fragment, _ := Xor(ct[offset:end], keyStream[:end-offset])
+		for _, b := range fragment {
+			if !isPrintable(b) {
+				fit.Printable = false
+			}
+		}
+		total += scorer.Score(fragment)
+		numFragments++
+	}
+	if numFragments == 0 {
+		fit.Score = math.Inf(-1)
+	} else {
+		fit.Score = total / float64(numFragments)
+	}
+	return fit, nil
+}
+
+// Drag tries crib at every offset in message msg and returns the fits,
+// best first. Fits with conflicts or non-printable fragments sort last.
+func (s *CribSession) Drag(msg int, crib []byte, scorer Scorer) ([]CribFit, error) {
+	if msg < 0 || msg >= len(s.ctxts) {
+		return nil, fmt.Errorf("No message %d", msg)
+	}
+	var fits []CribFit
+	for offset := 0; offset+len(crib) <= len(s.ctxts[msg]); offset++ {
+		fit, err := s.Fit(msg, offset, crib, scorer)
+		if err != nil {
+			return nil, err
+		}
+		fits = append(fits, fit)
+	}
+	sort.SliceStable(fits, func(i, j int) bool {
+		a, b := fits[i], fits[j]
+		if (a.Conflicts == 0) != (b.Conflicts == 0) {
+			return a.Conflicts == 0
+		}
+		if a.Printable != b.Printable {
+			return a.Printable
+		}
+		return a.Score > b.Score
+	})
+	return fits, nil
+}
+
+// AutoFill guesses every unknown keystream byte independently, by scoring
+// the column of ciphertext bytes at that position. Known bytes are left
+// alone. It is a single undoable edit.
+func (s *CribSession) AutoFill(scorer Scorer) {
+	guess := make([]byte, len(s.keyStream))
+	mask := make([]bool, len(s.keyStream))
+	for i := range guess {
+		if s.known[i] {
+			continue
+		}
+		var column []byte
+		for _, ct := range s.ctxts {
+			if len(ct) > i {
+				column = append(column, ct[i])
+			}
+		}
+		_, _, guess[i] = SolveSingleByteXor(column, scorer)
+		mask[i] = true
+	}
+	s.setKeyStream(0, guess, mask)
+}
+
+// KeyStream exports the recovered keystream and which bytes of it are known
+func (s *CribSession) KeyStream() ([]byte, []bool) {
+	keyStream := make([]byte, len(s.keyStream))
+	known := make([]bool, len(s.known))
+	copy(keyStream, s.keyStream)
+	copy(known, s.known)
+	return keyStream, known
+}
+
+// PlainText decrypts message msg with the current keystream. Bytes with
+// unknown keystream are UnknownByte.
+func (s *CribSession) PlainText(msg int) []byte {
+	ct := s.ctxts[msg]
+	msgBuf := make([]byte, len(ct))
+	for i := range ct {
+		if s.known[i] {
+			msgBuf[i] = ct[i] ^ s.keyStream[i]
+		} else {
+			msgBuf[i] = UnknownByte
+		}
+	}
+	return msgBuf
+}
+
+func (s *CribSession) PlainTexts() [][]byte {
+	msgs := make([][]byte, len(s.ctxts))
+	for i := range s.ctxts {
+		msgs[i] = s.PlainText(i)
+	}
+	return msgs
+}
+
+func isPrintable(b byte) bool {
+	return (b >= 0x20 && b < 0x7f) || b == '\n' || b == '\r' || b == '\t'
+}
@@ -0,0 +1,99 @@
+package cpals
+
+import (
+	"testing"
+)
+
+func TestCribSession(t *testing.T) {
+	msgs := [][]byte{
+		[]byte("Attack the east wall at dawn"),
+		[]byte("Retreat to the river by noon"),
+		[]byte("Hold the bridge until relieved"),
+	}
+	keyStream := RandomBytes(64)
+	var ctxts [][]byte
+	for _, m := range msgs {
+		ct, _ := Xor(m, keyStream[:len(m)])
+		ctxts = append(ctxts, ct)
+	}
+
+	session, err := NewCribSession(ctxts)
+	if err != nil {
+		t.Fatalf("Can't create session: %s", err)
+	}
+
+	crib := []byte(" the ")
+	fits, err := session.Drag(1, crib, EnglishScorer)
+	if err != nil {
+		t.Fatalf("Can't drag: %s", err)
+	}
+	found := false
+	for _, fit := range fits {
+		if !fit.Printable {
+			break
+		}
+		if fit.Offset == 10 {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Correct offset not among printable fits: %v", fits[:3])
+	}
+
+	err = session.Place(1, 10, crib)
+	if err != nil {
+		t.Fatalf("Can't place: %s", err)
+	}
+	got := session.PlainText(0)
+	if string(got[10:15]) != " east" {
+		t.Fatalf("Crib didn't propagate: %s", got)
+	}
+	t.Logf("After crib: %s", got)
+
+	fit, err := session.Fit(0, 10, []byte("XXXXX"), EnglishScorer)
+	if err != nil {
+		t.Fatalf("Can't fit: %s", err)
+	}
+	if fit.Conflicts != 5 {
+		t.Fatalf("Expected 5 conflicts got %d", fit.Conflicts)
+	}
+
+	err = session.Place(0, 0, msgs[0])
+	if err != nil {
+		t.Fatalf("Can't place whole message: %s", err)
+	}
+	got = session.PlainText(2)
+	if string(got[:len(msgs[0])]) != string(msgs[2][:len(msgs[0])]) {
+		t.Fatalf("Got %s expected prefix of %s", got, msgs[2])
+	}
+
+	err = session.Undo()
+	if err != nil {
+		t.Fatalf("Can't undo: %s", err)
+	}
+	ks, known := session.KeyStream()
+	for i := range known {
+		isCrib := i >= 10 && i < 15
+		if known[i] != isCrib {
+			t.Fatalf("Position %d known %v after undo", i, known[i])
+		}
+		if isCrib && ks[i] != keyStream[i] {
+			t.Fatalf("Wrong keystream at %d", i)
+		}
+	}
+
+	err = session.Undo()
+	if err != nil {
+		t.Fatalf("Can't undo: %s", err)
+	}
+	err = session.Undo()
+	if err == nil {
+		t.Fatalf("Didn't error on empty undo")
+	}
+
+	err = session.Place(2, 28, crib)
+	if err == nil {
+		t.Fatalf("Didn't error on crib off the end")
+	}
+	t.Logf("Crib off the end errored ok: %s", err)
+}
@@ -0,0 +1,186 @@
+package cpals
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// CounterLayout says where the counter sits in a CTR counter block. The
+// rest of the block is the nonce, which never changes. The counter wraps
+// within its own bytes, rather than carrying into the nonce.
+type CounterLayout struct {
+	// Offset is the byte offset of the counter in the block
+	Offset int
+	// Size is the counter length in bytes: 4, 8 or 16
+	Size      int
+	BigEndian bool
+}
+
+var (
+	// AESCTRLayout is the cryptopals layout used by AESCTR: a 64 bit
+	// little-endian nonce then a 64 bit little-endian block count
+	AESCTRLayout = CounterLayout{Offset: 8, Size: 8}
+	// FullCTRLayout treats the whole block as one big-endian counter, as
+	// crypto/cipher.NewCTR does (except that it wraps)
+	FullCTRLayout = CounterLayout{Offset: 0, Size: 16, BigEndian: true}
+	// GCMCTRLayout has a 96 bit nonce and a 32 bit big-endian counter
+	GCMCTRLayout = CounterLayout{Offset: 12, Size: 4, BigEndian: true}
+)
+
+func (l CounterLayout) check(blockSize int) error {
+	switch l.Size {
+	case 4, 8, 16:
+	default:
+		return fmt.Errorf("Counter size must be 4, 8 or 16 bytes, not %d", l.Size)
+	}
+	if l.Offset < 0 || l.Offset+l.Size > blockSize {
+		return fmt.Errorf("Counter at %d+%d doesn't fit in block of %d", l.Offset, l.Size, blockSize)
+	}
+	return nil
+}
+
+// add adds n to the counter in block, with carries from the least
+// significant byte
+func (l CounterLayout) add(block []byte, n uint64) {
+	counter := block[l.Offset : l.Offset+l.Size]
+	carry := uint64(0)
+	for i := 0; i < l.Size && (n != 0 || carry != 0); i++ {
+		j := i
+		if l.BigEndian {
+			j = l.Size - 1 - i
+		}
+		sum := uint64(counter[j]) + n&0xff + carry
+		counter[j] = byte(sum)
+		carry = sum >> 8
+		n >>= 8
+	}
+}
+
+// ctrParallelMin is the smallest buffer worth splitting between workers
+const ctrParallelMin = 64 * 1024
+
+// CTR is counter mode over any block cipher. It is a cipher.Stream which
+// can also seek, generating keystream at any offset without generating
+// what comes before. Large buffers are split between several goroutines,
+// so the block cipher must be safe for concurrent use (crypto/aes is).
+type CTR struct {
+	b       cipher.Block
+	iv      []byte
+	layout  CounterLayout
+	pos     int64
+	workers int
+}
+
+// NewCTR creates a CTR stream starting at counter block iv
+func NewCTR(b cipher.Block, iv []byte, layout CounterLayout) (*CTR, error) {
+	if err := layout.check(b.BlockSize()); err != nil {
+		return nil, err
+	}
+	ivCopy, err := copyIV(b, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &CTR{b: b, iv: ivCopy, layout: layout, workers: runtime.NumCPU()}, nil
+}
+
+// NewAESCTR creates the CTR stream used by AESCTR
+func NewAESCTR(key []byte, nonce int64) (*CTR, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Can't create aes cipher: %w", err)
+	}
+	iv := make([]byte, b.BlockSize())
+	binary.LittleEndian.PutUint64(iv, uint64(nonce))
+	return NewCTR(b, iv, AESCTRLayout)
+}
+
+// SetWorkers sets how many goroutines share large buffers. The default is
+// one per CPU, and 1 keeps everything on the calling goroutine.
+func (c *CTR) SetWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	c.workers = n
+}
+
+func (c *CTR) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("crypto/cipher: output smaller than input")
+	}
+	if c.workers == 1 || len(src) < ctrParallelMin {
+		c.xorAt(dst, src, c.pos)
+		c.pos += int64(len(src))
+		return
+	}
+
+	// Split on block boundaries, so no block is encrypted twice
+	bs := c.b.BlockSize()
+	chunk := (len(src)/c.workers + bs - 1) / bs * bs
+	var wg sync.WaitGroup
+	for start := 0; start < len(src); start += chunk {
+		end := start + chunk
+		if end > len(src) {
+			end = len(src)
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			c.xorAt(dst[start:end], src[start:end], c.pos+int64(start))
+		}(start, end)
+	}
+	wg.Wait()
+	c.pos += int64(len(src))
+}
+
+// xorAt XORs src with the keystream starting at byte pos. It doesn't
+// touch the stream state, so can run concurrently.
+func (c *CTR) xorAt(dst, src []byte, pos int64) {
+	bs := c.b.BlockSize()
+	counter := make([]byte, bs)
+	copy(counter, c.iv)
+	c.layout.add(counter, uint64(pos/int64(bs)))
+	ks := make([]byte, bs)
+	offset := int(pos % int64(bs))
+	for len(src) > 0 {
+		c.b.Encrypt(ks, counter)
+		n := xorInto(dst, src, ks[offset:])
+		dst = dst[n:]
+		src = src[n:]
+		offset = 0
+		c.layout.add(counter, 1)
+	}
+}
+
+// Seek moves to a byte offset in the keystream. There is no end to seek
+// from.
+func (c *CTR) Seek(offset int64, whence int) (int64, error) {
+	pos := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		pos += c.pos
+	case io.SeekEnd:
+		return c.pos, errors.New("Can't seek from the end of a keystream")
+	default:
+		return c.pos, fmt.Errorf("Bad whence %d", whence)
+	}
+	if pos < 0 {
+		return c.pos, fmt.Errorf("Can't seek to negative position %d", pos)
+	}
+	c.pos = pos
+	return pos, nil
+}
+
+// KeyStream returns n bytes of keystream from the current position, and
+// moves past them
+func (c *CTR) KeyStream(n int) []byte {
+	ks := make([]byte, n)
+	c.XORKeyStream(ks, ks)
+	return ks
+}
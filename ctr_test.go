@@ -0,0 +1,174 @@
+package cpals
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// oldAESCTRKeyStream is how AESCTRKeyStream used to work, kept to check
+// and benchmark against
+func oldAESCTRKeyStream(key []byte, nonce int64, bufLen int) []byte {
+	ret := make([]byte, bufLen)
+	blockCount := int64(0)
+	for i := 0; i < bufLen; i += len(key) {
+		w := &bytes.Buffer{}
+		binary.Write(w, binary.LittleEndian, nonce)
+		binary.Write(w, binary.LittleEndian, blockCount)
+		buf := AESECBEncrypt(key, w.Bytes())
+		copy(ret[i:], buf)
+		blockCount++
+	}
+	return ret
+}
+
+func TestCTRMatchesOld(t *testing.T) {
+	key := RandomKey()
+	nonce := int64(-12345)
+	expected := oldAESCTRKeyStream(key, nonce, 1000)
+	got := AESCTRKeyStream(key, nonce, 1000)
+	if !BytesEqual(got, expected) {
+		t.Fatalf("Got %s expected %s", EnHex(got[:32]), EnHex(expected[:32]))
+	}
+}
+
+func TestCTRMatchesStdlib(t *testing.T) {
+	block, _ := aes.NewCipher(RandomKey())
+	// Close to a carry across several bytes
+	iv := mustDeHex(t, "0102030405060708090a0bfffffffffe")
+	msg := RandomBytes(200)
+
+	expected := make([]byte, len(msg))
+	cipher.NewCTR(block, iv).XORKeyStream(expected, msg)
+	ctr, err := NewCTR(block, iv, FullCTRLayout)
+	if err != nil {
+		t.Fatalf("Can't create CTR: %s", err)
+	}
+	got := make([]byte, len(msg))
+	ctr.XORKeyStream(got, msg)
+	if !BytesEqual(got, expected) {
+		t.Fatalf("Got %s expected %s", EnHex(got), EnHex(expected))
+	}
+}
+
+func TestCTRLayouts(t *testing.T) {
+	testCases := []struct {
+		layout   CounterLayout
+		start    string
+		add      uint64
+		expected string
+	}{
+		{AESCTRLayout, "00000000000000000000000000000000", 0x1ff, "0000000000000000ff01000000000000"},
+		{AESCTRLayout, "aaaaaaaaaaaaaaaaffffffffffffffff", 1, "aaaaaaaaaaaaaaaa0000000000000000"},
+		{GCMCTRLayout, "aaaaaaaaaaaaaaaaaaaaaaaafffffffe", 3, "aaaaaaaaaaaaaaaaaaaaaaaa00000001"},
+		{FullCTRLayout, "0000000000000000ffffffffffffffff", 1, "00000000000000010000000000000000"},
+		{CounterLayout{Offset: 4, Size: 4}, "aaaaaaaafeffffffaaaaaaaaaaaaaaaa", 2, "aaaaaaaa00000000aaaaaaaaaaaaaaaa"},
+	}
+	for _, tc := range testCases {
+		block := mustDeHex(t, tc.start)
+		tc.layout.add(block, tc.add)
+		if string(EnHex(block)) != tc.expected {
+			t.Fatalf("%+v: %s + %d = %s, expected %s", tc.layout, tc.start, tc.add, EnHex(block), tc.expected)
+		}
+	}
+
+	block, _ := aes.NewCipher(RandomKey())
+	iv := make([]byte, AESBlockSize)
+	for _, bad := range []CounterLayout{{Offset: 0, Size: 3}, {Offset: 12, Size: 8}, {Offset: -1, Size: 4}} {
+		_, err := NewCTR(block, iv, bad)
+		if err == nil {
+			t.Fatalf("%+v: didn't error", bad)
+		}
+		t.Logf("%+v: errored ok: %s", bad, err)
+	}
+}
+
+func TestCTRSeek(t *testing.T) {
+	key := RandomKey()
+	whole := AESCTRKeyStream(key, 99, 1000)
+
+	ctr, _ := NewAESCTR(key, 99)
+	for _, offset := range []int64{999, 0, 17, 500, 16} {
+		pos, err := ctr.Seek(offset, io.SeekStart)
+		if err != nil || pos != offset {
+			t.Fatalf("Can't seek to %d: %d %s", offset, pos, err)
+		}
+		got := ctr.KeyStream(1000 - int(offset))
+		if !BytesEqual(got, whole[offset:]) {
+			t.Fatalf("Wrong keystream at %d", offset)
+		}
+	}
+
+	ctr.Seek(10, io.SeekStart)
+	pos, _ := ctr.Seek(5, io.SeekCurrent)
+	if pos != 15 {
+		t.Fatalf("Relative seek to %d", pos)
+	}
+	_, err := ctr.Seek(-20, io.SeekCurrent)
+	if err == nil {
+		t.Fatalf("Didn't error on negative seek")
+	}
+	_, err = ctr.Seek(0, io.SeekEnd)
+	if err == nil {
+		t.Fatalf("Didn't error on seek from end")
+	}
+}
+
+func TestCTRParallel(t *testing.T) {
+	key := RandomKey()
+	msg := RandomBytes(3*ctrParallelMin + 7)
+
+	serial, _ := NewAESCTR(key, 1)
+	serial.SetWorkers(1)
+	expected := make([]byte, len(msg))
+	serial.XORKeyStream(expected[:5], msg[:5])
+	serial.XORKeyStream(expected[5:], msg[5:])
+
+	parallel, _ := NewAESCTR(key, 1)
+	parallel.SetWorkers(7)
+	got := make([]byte, len(msg))
+	parallel.XORKeyStream(got[:5], msg[:5])
+	parallel.XORKeyStream(got[5:], msg[5:])
+	if !BytesEqual(got, expected) {
+		t.Fatalf("Parallel doesn't match serial")
+	}
+
+	// And in place
+	parallel.Seek(0, io.SeekStart)
+	parallel.XORKeyStream(got, got)
+	if !BytesEqual(got, msg) {
+		t.Fatalf("Can't round trip in place")
+	}
+}
+
+const ctrBenchLen = 1 << 20
+
+func BenchmarkCTROld(b *testing.B) {
+	key := RandomKey()
+	b.SetBytes(ctrBenchLen)
+	for i := 0; i < b.N; i++ {
+		oldAESCTRKeyStream(key, 0, ctrBenchLen)
+	}
+}
+
+func BenchmarkCTRSerial(b *testing.B) {
+	ctr, _ := NewAESCTR(RandomKey(), 0)
+	ctr.SetWorkers(1)
+	buf := make([]byte, ctrBenchLen)
+	b.SetBytes(ctrBenchLen)
+	for i := 0; i < b.N; i++ {
+		ctr.XORKeyStream(buf, buf)
+	}
+}
+
+func BenchmarkCTRParallel(b *testing.B) {
+	ctr, _ := NewAESCTR(RandomKey(), 0)
+	buf := make([]byte, ctrBenchLen)
+	b.SetBytes(ctrBenchLen)
+	for i := 0; i < b.N; i++ {
+		ctr.XORKeyStream(buf, buf)
+	}
+}
@@ -0,0 +1,147 @@
+package cpals
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ECBTemplate describes what an ECB oracle encrypts: Prefix, then our
+// input after Filter, then Suffix, padded with Padder
+type ECBTemplate struct {
+	Prefix []byte
+	Suffix []byte
+	// Filter is what the oracle does to our input. Default none.
+	Filter func([]byte) []byte
+	// Padder is the oracle's padding. Default PKCS7.
+	Padder Padder
+}
+
+func (tmpl *ECBTemplate) defaults() {
+	if tmpl.Filter == nil {
+		tmpl.Filter = func(in []byte) []byte { return in }
+	}
+	if tmpl.Padder == nil {
+		tmpl.Padder = PKCS7Padder{}
+	}
+}
+
+// plaintext is what the oracle encrypts for input
+func (tmpl ECBTemplate) plaintext(input []byte, blockSize int) []byte {
+	buf := append([]byte{}, tmpl.Prefix...)
+	buf = append(buf, tmpl.Filter(input)...)
+	buf = append(buf, tmpl.Suffix...)
+	return tmpl.Padder.Pad(buf, blockSize)
+}
+
+// ErrUnreachable is returned when no input gets a target block past the
+// oracle's filter and on to a block boundary. Only inputs holding the
+// target bytes themselves are tried, not ones the filter might turn in to
+// them.
+var ErrUnreachable = errors.New("Target block unreachable")
+
+// CutPiece says where a target block comes from: block Block of the
+// ciphertext for Input
+type CutPiece struct {
+	Input []byte
+	Block int
+}
+
+// CutAndPaste forges ECB ciphertexts by splicing together blocks which an
+// oracle encrypted for us
+type CutAndPaste struct {
+	oracle    Oracle
+	tmpl      ECBTemplate
+	blockSize int
+	// maxInput is the longest input tried. Three blocks is enough to put
+	// a whole block of input on a boundary, after any prefix.
+	maxInput int
+}
+
+// NewCutAndPaste checks the oracle's ciphertext lengths fit the template
+func NewCutAndPaste(oracle Oracle, tmpl ECBTemplate) (*CutAndPaste, error) {
+	tmpl.defaults()
+	blockSize, err := oracle.FindBlockSize()
+	if err != nil {
+		return nil, fmt.Errorf("Can't find block size: %w", err)
+	}
+	c := &CutAndPaste{
+		oracle:    oracle,
+		tmpl:      tmpl,
+		blockSize: blockSize,
+		maxInput:  3 * blockSize,
+	}
+	for n := 0; n < blockSize; n++ {
+		input := NewBytes(n, 'A')
+		got, expected := len(oracle(input)), len(tmpl.plaintext(input, blockSize))
+		if got != expected {
+			return nil, fmt.Errorf("Template doesn't fit oracle: %d bytes of input gave %d bytes, not %d", n, got, expected)
+		}
+	}
+	return c, nil
+}
+
+// Plan finds an input for each block of the padded target which puts
+// that block on a block boundary, unchanged by the filter. Blocks already
+// in the template are used as they are, by sending short inputs first.
+func (c *CutAndPaste) Plan(target []byte) ([]CutPiece, error) {
+	bs := c.blockSize
+	padded := c.tmpl.Padder.Pad(target, bs)
+	var plan []CutPiece
+	for i := 0; i < len(padded); i += bs {
+		piece, ok := c.place(padded[i : i+bs])
+		if !ok {
+			return nil, fmt.Errorf("Block %d %q: %w", i/bs, padded[i:i+bs], ErrUnreachable)
+		}
+		plan = append(plan, piece)
+	}
+	return plan, nil
+}
+
+// place tries each input length and each block, filling the input bytes
+// which fall in the block from want. The prefix, suffix and padding then
+// have to match by themselves.
+func (c *CutAndPaste) place(want []byte) (CutPiece, bool) {
+	bs := c.blockSize
+	start := len(c.tmpl.Prefix)
+	for n := 0; n <= c.maxInput; n++ {
+		numBlocks := (start+n+len(c.tmpl.Suffix))/bs + 1
+		for block := 0; block < numBlocks; block++ {
+			input := NewBytes(n, 'A')
+			for i := 0; i < bs; i++ {
+				j := block*bs + i - start
+				if j >= 0 && j < n {
+					input[j] = want[i]
+				}
+			}
+			plain := c.tmpl.plaintext(input, bs)
+			if (block+1)*bs <= len(plain) && BytesEqual(plain[block*bs:(block+1)*bs], want) {
+				return CutPiece{Input: input, Block: block}, true
+			}
+		}
+	}
+	return CutPiece{}, false
+}
+
+// Forge plans the target, collects the blocks and splices them together
+func (c *CutAndPaste) Forge(target []byte) ([]byte, error) {
+	plan, err := c.Plan(target)
+	if err != nil {
+		return nil, err
+	}
+	bs := c.blockSize
+	seen := make(map[string][]byte)
+	var forged []byte
+	for _, piece := range plan {
+		buf, ok := seen[string(piece.Input)]
+		if !ok {
+			buf = c.oracle(piece.Input)
+			seen[string(piece.Input)] = buf
+		}
+		expected := len(c.tmpl.plaintext(piece.Input, bs))
+		if len(buf) != expected {
+			return nil, fmt.Errorf("Template doesn't fit oracle: %q gave %d bytes, not %d", piece.Input, len(buf), expected)
+		}
+		forged = append(forged, buf[piece.Block*bs:(piece.Block+1)*bs]...)
+	}
+	return forged, nil
+}
@@ -0,0 +1,109 @@
+package cpals
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCutAndPaste(t *testing.T) {
+	key := RandomKey()
+	// The prefix leaves our input 5 bytes in to a block
+	tmpl := ECBTemplate{
+		Prefix: []byte("name="),
+		Suffix: []byte(";admin=false"),
+		Filter: func(in []byte) []byte {
+			return bytes.ReplaceAll(in, []byte(";"), nil)
+		},
+	}
+	ecbWith := func(padder Padder) Oracle {
+		return func(msg []byte) []byte {
+			buf := append([]byte{}, tmpl.Prefix...)
+			buf = append(buf, tmpl.Filter(msg)...)
+			buf = append(buf, tmpl.Suffix...)
+			ctxt, err := AESECBEncryptWith(key, buf, padder)
+			if err != nil {
+				panic(err.Error())
+			}
+			return ctxt
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		padder Padder
+	}{
+		{"PKCS7", PKCS7Padder{}},
+		{"ANSI X.923", ANSIX923Padder{}},
+		{"ISO 7816", ISO7816Padder{}},
+	}
+	for _, tc := range testCases {
+		tmpl.Padder = tc.padder
+		oracle := ecbWith(tc.padder)
+		cp, err := NewCutAndPaste(oracle, tmpl)
+		if err != nil {
+			t.Fatalf("%s: Can't create cut and paste: %s", tc.name, err)
+		}
+
+		// The ';' can only come from the suffix, and the padding block
+		// has to be made for this padder
+		target := []byte("name=mallory;admin=true")
+		forged, err := cp.Forge(target)
+		if err != nil {
+			t.Fatalf("%s: Can't forge: %s", tc.name, err)
+		}
+		got, err := AESECBDecryptWith(key, forged, tc.padder)
+		if err != nil {
+			t.Fatalf("%s: Can't decrypt forgery: %s", tc.name, err)
+		}
+		if !BytesEqual(got, target) {
+			t.Fatalf("%s: Forgery decrypts to %q", tc.name, got)
+		}
+		t.Logf("%s: forged %q", tc.name, got)
+	}
+
+	// The template's own plaintext needs no input at all
+	tmpl.Padder = PKCS7Padder{}
+	oracle := ecbWith(tmpl.Padder)
+	cp, err := NewCutAndPaste(oracle, tmpl)
+	if err != nil {
+		t.Fatalf("Can't create cut and paste: %s", err)
+	}
+	plan, err := cp.Plan([]byte("name=;admin=false"))
+	if err != nil {
+		t.Fatalf("Can't plan template blocks: %s", err)
+	}
+	for i, piece := range plan {
+		if len(piece.Input) != 0 || piece.Block != i {
+			t.Fatalf("Block %d came from block %d of %q", i, piece.Block, piece.Input)
+		}
+	}
+	forged, err := cp.Forge([]byte("name=;admin=false"))
+	if err != nil || !BytesEqual(forged, oracle(nil)) {
+		t.Fatalf("Template blocks not reused: %v", err)
+	}
+	t.Logf("Reused %d template blocks", len(plan))
+
+	// A ';' followed by "admin=true" is never in the suffix
+	_, err = cp.Forge([]byte("name=mallory;admin=false;admin=true"))
+	if !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Expected unreachable target, got %v", err)
+	}
+	t.Logf("errored ok: %s", err)
+}
+
+func TestCutAndPasteTemplateMismatch(t *testing.T) {
+	key := RandomKey()
+	oracle := Oracle(func(msg []byte) []byte {
+		return AESECBEncrypt(key, append([]byte("name="), msg...))
+	})
+	// Says there is a suffix when there isn't
+	_, err := NewCutAndPaste(oracle, ECBTemplate{
+		Prefix: []byte("name="),
+		Suffix: []byte(";admin=false"),
+	})
+	if err == nil {
+		t.Fatalf("Didn't error on wrong template")
+	}
+	t.Logf("errored ok: %s", err)
+}
@@ -0,0 +1,191 @@
+package cpals
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ECBRepeat is one block value seen more than once in a record
+type ECBRepeat struct {
+	Block []byte
+	// Byte offsets of every occurrence within the record
+	Positions []int
+}
+
+// ECBFinding is what one block size and alignment found in a record
+type ECBFinding struct {
+	BlockSize int
+	// Offset of the first block boundary, for ciphertexts with a header
+	Offset int
+	// Number of blocks which duplicate an earlier block
+	Repeats    int
+	NumBlocks  int
+	Duplicates []ECBRepeat
+}
+
+func (f ECBFinding) RepeatedBytes() int {
+	return f.Repeats * f.BlockSize
+}
+
+// ECBReport gives the findings for one record, best first
+type ECBReport struct {
+	Record int
+	Len    int
+	// Repeated bytes in the best finding
+	RepeatedBytes int
+	Findings      []ECBFinding
+}
+
+// Best is the finding with the most repeated bytes
+func (r ECBReport) Best() ECBFinding {
+	return r.Findings[0]
+}
+
+type ECBDetector struct {
+	// Block sizes to check, which must be positive. Defaults to 8 and 16
+	BlockSizes []int
+	// Check every block alignment, not just blocks from the start of the
+	// record
+	AllAlignments bool
+	// Splits the input into records. Defaults to lines
+	Split bufio.SplitFunc
+	// Turns a record into ciphertext. Defaults to hex decoding
+	Decode func([]byte) ([]byte, error)
+	// Largest record the scanner accepts. Defaults to 1MiB
+	MaxRecordLen int
+}
+
+func (d ECBDetector) defaults() ECBDetector {
+	if len(d.BlockSizes) == 0 {
+		d.BlockSizes = []int{8, 16}
+	}
+	if d.Split == nil {
+		d.Split = bufio.ScanLines
+	}
+	if d.Decode == nil {
+		d.Decode = func(record []byte) ([]byte, error) {
+			return DeHex(HexStr(bytes.TrimSpace(record)))
+		}
+	}
+	if d.MaxRecordLen == 0 {
+		d.MaxRecordLen = 1 << 20
+	}
+	return d
+}
+
+func (d ECBDetector) check() error {
+	for _, blockSize := range d.BlockSizes {
+		if blockSize <= 0 {
+			return fmt.Errorf("Bad block size %d", blockSize)
+		}
+	}
+	return nil
+}
+
+// DetectECB reads hex ciphertexts, one per line, and reports those with
+// repeated blocks
+func DetectECB(r io.Reader) ([]ECBReport, error) {
+	return ECBDetector{}.Detect(r)
+}
+
+// Detect streams records from r and returns a report for each record with
+// any repeated blocks, ranked by number of repeated bytes. Records are
+// numbered from zero in input order, counting empty ones.
+func (d ECBDetector) Detect(r io.Reader) ([]ECBReport, error) {
+	d = d.defaults()
+	if err := d.check(); err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, d.MaxRecordLen)
+	scanner.Split(d.Split)
+
+	var reports []ECBReport
+	for i := 0; scanner.Scan(); i++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		buf, err := d.Decode(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("Can't decode record %d: %w", i, err)
+		}
+		report, err := d.Check(buf)
+		if err != nil {
+			return nil, err
+		}
+		if report.RepeatedBytes == 0 {
+			continue
+		}
+		report.Record = i
+		reports = append(reports, report)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Can't read records: %w", err)
+	}
+
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].RepeatedBytes > reports[j].RepeatedBytes
+	})
+	return reports, nil
+}
+
+// Check finds repeated blocks in a single ciphertext
+func (d ECBDetector) Check(buf []byte) (ECBReport, error) {
+	d = d.defaults()
+	report := ECBReport{Len: len(buf)}
+	if err := d.check(); err != nil {
+		return report, err
+	}
+	for _, blockSize := range d.BlockSizes {
+		numOffsets := 1
+		if d.AllAlignments {
+			numOffsets = blockSize
+		}
+		for offset := 0; offset < numOffsets && offset < len(buf); offset++ {
+			f := findRepeatedBlocks(buf, blockSize, offset)
+			if f.Repeats > 0 {
+				report.Findings = append(report.Findings, f)
+			}
+		}
+	}
+	// A repeated 16 byte block is also two repeated 8 byte blocks, so we
+	// compare repeated bytes and prefer the larger block size on a tie
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		a, b := report.Findings[i], report.Findings[j]
+		if a.RepeatedBytes() != b.RepeatedBytes() {
+			return a.RepeatedBytes() > b.RepeatedBytes()
+		}
+		return a.BlockSize > b.BlockSize
+	})
+	if len(report.Findings) > 0 {
+		report.RepeatedBytes = report.Best().RepeatedBytes()
+	}
+	return report, nil
+}
+
+func findRepeatedBlocks(buf []byte, blockSize, offset int) ECBFinding {
+	f := ECBFinding{BlockSize: blockSize, Offset: offset}
+	chunks, _ := BytesToChunks(buf[offset:], blockSize)
+	f.NumBlocks = len(chunks)
+
+	positions := make(map[string][]int)
+	var order []string
+	for i, c := range chunks {
+		s := string(c)
+		if _, ok := positions[s]; !ok {
+			order = append(order, s)
+		} else {
+			f.Repeats++
+		}
+		positions[s] = append(positions[s], offset+i*blockSize)
+	}
+	for _, s := range order {
+		if len(positions[s]) > 1 {
+			f.Duplicates = append(f.Duplicates, ECBRepeat{[]byte(s), positions[s]})
+		}
+	}
+	return f
+}
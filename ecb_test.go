@@ -0,0 +1,93 @@
+package cpals
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestECBDetector(t *testing.T) {
+	// A fixed key, since the header could by chance match the end of the
+	// ECB block and make another alignment look as good
+	key := YellowKey
+	// The second record has a 5 byte header before the ECB blocks
+	ecb := AESECBEncrypt(key, bytes.Repeat([]byte("YELLOW SUBMARINE"), 4))
+	header := append([]byte("HEAD!"), AESECBEncrypt(key, bytes.Repeat([]byte("YELLOW SUBMARINE"), 6))...)
+	random := RandomBytes(96)
+
+	var lines []string
+	for _, buf := range [][]byte{random, header, nil, ecb} {
+		lines = append(lines, string(EnBase64(buf)))
+	}
+	input := strings.Join(lines, "\n")
+
+	d := ECBDetector{
+		AllAlignments: true,
+		Decode: func(record []byte) ([]byte, error) {
+			return DeBase64(B64Str(record))
+		},
+	}
+	reports, err := d.Detect(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Can't detect: %s", err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("Expected 2 reports, got %d", len(reports))
+	}
+	// The header record has more repeats so ranks first
+	if reports[0].Record != 1 || reports[1].Record != 3 {
+		t.Fatalf("Wrong records %d, %d", reports[0].Record, reports[1].Record)
+	}
+	best := reports[0].Best()
+	if best.BlockSize != AESBlockSize || best.Offset != 5 || best.Repeats != 5 {
+		t.Fatalf("Wrong finding %+v", best)
+	}
+	if !BytesEqual(best.Duplicates[0].Block, header[5:5+AESBlockSize]) {
+		t.Fatalf("Wrong duplicate block")
+	}
+	positions := best.Duplicates[0].Positions
+	if len(positions) != 6 || positions[0] != 5 || positions[5] != 85 {
+		t.Fatalf("Wrong positions %v", positions)
+	}
+
+	_, err = DetectECB(strings.NewReader("not hex\n"))
+	if err == nil {
+		t.Fatalf("Didn't error on bad hex")
+	}
+	t.Logf("Bad hex errored ok: %s", err)
+}
+
+func TestECBDetectorBlockSize(t *testing.T) {
+	// An 8 byte block cipher shouldn't look like a 16 byte one
+	buf := RandomBytes(64)
+	copy(buf[8:], buf[:8])
+	copy(buf[32:], buf[:8])
+	report, err := ECBDetector{}.Check(buf)
+	if err != nil {
+		t.Fatalf("Can't check: %s", err)
+	}
+	if report.Best().BlockSize != 8 || report.Best().Repeats != 2 {
+		t.Fatalf("Wrong finding %+v", report.Best())
+	}
+
+	report, err = ECBDetector{BlockSizes: []int{16}}.Check(buf)
+	if err != nil {
+		t.Fatalf("Can't check: %s", err)
+	}
+	if report.RepeatedBytes != 0 {
+		t.Fatalf("Found repeats at block size 16: %+v", report.Findings)
+	}
+
+	for _, blockSizes := range [][]int{{0}, {16, -8}} {
+		d := ECBDetector{BlockSizes: blockSizes}
+		_, err = d.Check(buf)
+		if err == nil {
+			t.Fatalf("Check didn't error on block sizes %v", blockSizes)
+		}
+		_, err = d.Detect(strings.NewReader(string(EnHex(buf))))
+		if err == nil {
+			t.Fatalf("Detect didn't error on block sizes %v", blockSizes)
+		}
+		t.Logf("Block sizes %v errored ok: %s", blockSizes, err)
+	}
+}
@@ -0,0 +1,225 @@
+package cpals
+
+import (
+	"errors"
+	"fmt"
+)
+
+// findBlockSizeGCD finds the block size as the gcd of ciphertext lengths
+// over a range of message lengths. Unlike watching for the length to
+// jump, this copes with prefixes of random length.
+func (oracle Oracle) findBlockSizeGCD() (int, error) {
+	g := 0
+	for i := 0; i <= 64; i++ {
+		g = gcd(g, len(oracle(make([]byte, i))))
+	}
+	if g < 2 {
+		return 0, fmt.Errorf("Not a block cipher: lengths have gcd %d", g)
+	}
+	return g, nil
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// suffixAttack is byte-at-a-time ECB decryption of whatever the oracle
+// appends to our message, with any prefix it puts in front
+type suffixAttack struct {
+	oracle    Oracle
+	blockSize int
+	// marker is a random block. Two copies of it, encrypted side by side,
+	// show where our message starts being block aligned.
+	marker []byte
+	// guard goes either side of the marker pair, and differs from the
+	// marker in every byte, so neither the prefix nor our message can make
+	// a misaligned duplicate pair
+	guard []byte
+	// padLen is how much filler aligns the marker after a fixed prefix,
+	// or -1 if the prefix length varies and we have to keep trying. Then
+	// the filler length cycles, since the prefix might never be a whole
+	// number of blocks by itself.
+	padLen int
+	// prefixLen is the length of a fixed prefix, found along with padLen
+	prefixLen int
+	maxTries  int
+}
+
+func newSuffixAttack(oracle Oracle, blockSize int) *suffixAttack {
+	s := &suffixAttack{
+		oracle:    oracle,
+		blockSize: blockSize,
+		marker:    RandomBytes(blockSize),
+		maxTries:  20 * blockSize,
+	}
+	s.guard = make([]byte, blockSize)
+	for i := range s.guard {
+		s.guard[i] = ^s.marker[i]
+	}
+	return s
+}
+
+// DecryptSuffix recovers the secret an ECB oracle appends to its input.
+// It finds the block size, then works out how to align its input despite
+// any fixed or random length prefix, and recovers the secret a byte at a
+// time. Each byte takes one query for the target block and one for a
+// dictionary of all 256 candidate blocks.
+func (oracle Oracle) DecryptSuffix() ([]byte, error) {
+	blockSize, err := oracle.findBlockSizeGCD()
+	if err != nil {
+		return nil, err
+	}
+	s := newSuffixAttack(oracle, blockSize)
+	if err := s.align(); err != nil {
+		return nil, err
+	}
+	suffixLen, err := s.suffixLen()
+	if err != nil {
+		return nil, err
+	}
+
+	known := make([]byte, 0, suffixLen)
+	for i := 0; i < suffixLen; i++ {
+		b, err := s.nextByte(known)
+		if err != nil {
+			return known, fmt.Errorf("Can't find byte %d: %w", i, err)
+		}
+		known = append(known, b)
+	}
+	return known, nil
+}
+
+// try sends filler, the guard, the marker twice, the guard again, then msg.
+// If the marker came out block aligned, it returns the ciphertext and
+// the offset of the block after the second guard. From there on is the
+// encryption of msg and the suffix as if there were no prefix.
+func (s *suffixAttack) try(padLen int, msg []byte) ([]byte, int, bool) {
+	bs := s.blockSize
+	chosen := make([]byte, padLen, padLen+4*bs+len(msg))
+	chosen = append(chosen, s.guard...)
+	chosen = append(chosen, s.marker...)
+	chosen = append(chosen, s.marker...)
+	chosen = append(chosen, s.guard...)
+	chosen = append(chosen, msg...)
+	buf := s.oracle(chosen)
+	for i := 0; i+3*bs <= len(buf); i += bs {
+		if BytesEqual(buf[i:i+bs], buf[i+bs:i+2*bs]) {
+			return buf, i + 3*bs, true
+		}
+	}
+	return nil, 0, false
+}
+
+// align finds the filler length for a fixed prefix, which always works, or
+// settles for retrying with a random prefix
+func (s *suffixAttack) align() error {
+TRY_PAD:
+	for padLen := 0; padLen < s.blockSize; padLen++ {
+		at := 0
+		for i := 0; i < 3; i++ {
+			_, offset, ok := s.try(padLen, nil)
+			if !ok || (i > 0 && offset != at) {
+				continue TRY_PAD
+			}
+			at = offset
+		}
+		// Back past the guards, marker and filler
+		s.padLen = padLen
+		s.prefixLen = at - 4*s.blockSize - padLen
+		return nil
+	}
+
+	s.padLen = -1
+	for i := 0; i < s.maxTries; i++ {
+		if _, _, ok := s.try(i%s.blockSize, nil); ok {
+			return nil
+		}
+	}
+	return errors.New("Can't align input: not ECB?")
+}
+
+// query encrypts msg and the suffix, block aligned
+func (s *suffixAttack) query(msg []byte) ([]byte, error) {
+	for i := 0; i < s.maxTries; i++ {
+		padLen := s.padLen
+		if padLen < 0 {
+			padLen = i % s.blockSize
+		}
+		buf, at, ok := s.try(padLen, msg)
+		if ok {
+			return buf[at:], nil
+		}
+		// The prefix wasn't fixed after all
+		s.padLen = -1
+	}
+	return nil, errors.New("Lost alignment")
+}
+
+// suffixLen finds how much input makes the padding spill in to a new
+// block. Then input plus suffix is a whole number of blocks.
+func (s *suffixAttack) suffixLen() (int, error) {
+	baseLen, n, err := s.growth()
+	if err != nil {
+		return 0, err
+	}
+	return baseLen - n, nil
+}
+
+// growth returns the aligned ciphertext length for no input, and the
+// least input which makes it grow
+func (s *suffixAttack) growth() (int, int, error) {
+	buf, err := s.query(nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	baseLen := len(buf)
+	for n := 1; n <= s.blockSize; n++ {
+		buf, err := s.query(make([]byte, n))
+		if err != nil {
+			return 0, 0, err
+		}
+		if len(buf) > baseLen {
+			return baseLen, n, nil
+		}
+	}
+	return 0, 0, errors.New("Ciphertext never grew: not padded?")
+}
+
+// nextByte lines up the next unknown byte at the end of a block, then
+// looks up that block in a dictionary made from the last blockSize-1
+// known bytes and every possible final byte
+func (s *suffixAttack) nextByte(known []byte) (byte, error) {
+	bs := s.blockSize
+	padLen := bs - 1 - len(known)%bs
+	buf, err := s.query(make([]byte, padLen))
+	if err != nil {
+		return 0, err
+	}
+	target := len(known) / bs * bs
+	if target+bs > len(buf) {
+		return 0, fmt.Errorf("Ciphertext len %d too short", len(buf))
+	}
+	targetBlock := buf[target : target+bs]
+
+	// Build the whole dictionary in one query
+	window := append(make([]byte, padLen), known...)
+	window = window[len(window)-(bs-1):]
+	dictMsg := make([]byte, 0, 256*bs)
+	for b := 0; b < 256; b++ {
+		dictMsg = append(dictMsg, window...)
+		dictMsg = append(dictMsg, byte(b))
+	}
+	dict, err := s.query(dictMsg)
+	if err != nil {
+		return 0, err
+	}
+	for b := 0; b < 256; b++ {
+		if BytesEqual(dict[b*bs:(b+1)*bs], targetBlock) {
+			return byte(b), nil
+		}
+	}
+	return 0, errors.New("No dictionary match")
+}
@@ -0,0 +1,38 @@
+package cpals
+
+import (
+	"testing"
+)
+
+func TestDecryptSuffix(t *testing.T) {
+	key := RandomKey()
+	secret := []byte("Attack at dawn, bring the good biscuits")
+	withPrefix := func(prefix func() []byte) Oracle {
+		return func(msg []byte) []byte {
+			buf := append(prefix(), msg...)
+			return AESECBEncrypt(key, append(buf, secret...))
+		}
+	}
+	fixed := RandomBytes(21)
+
+	testCases := []struct {
+		name   string
+		oracle Oracle
+	}{
+		{"no prefix", withPrefix(func() []byte { return nil })},
+		{"fixed prefix", withPrefix(func() []byte { return fixed })},
+		// Never a whole block, so the filler has to do the aligning
+		{"short random prefix", withPrefix(func() []byte { return RandomRandomBytes(1, 15) })},
+		{"long random prefix", withPrefix(func() []byte { return RandomRandomBytes(17, 60) })},
+	}
+	for _, tc := range testCases {
+		got, err := tc.oracle.DecryptSuffix()
+		if err != nil {
+			t.Fatalf("%s: Can't decrypt suffix: %s", tc.name, err)
+		}
+		if !BytesEqual(got, secret) {
+			t.Fatalf("%s: Got %q", tc.name, got)
+		}
+		t.Logf("%s: decrypted %q", tc.name, got)
+	}
+}
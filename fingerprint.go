@@ -0,0 +1,406 @@
+package cpals
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Mode is how an oracle appears to use its cipher
+type Mode int
+
+const (
+	ModeUnknown Mode = iota
+	ModeECB
+	// ModeCBC is any block mode which hides repeated blocks
+	ModeCBC
+	// ModeStream is CTR, or anything else with no blocks or padding
+	ModeStream
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeECB:
+		return "ECB"
+	case ModeCBC:
+		return "CBC"
+	case ModeStream:
+		return "stream"
+	}
+	return "unknown"
+}
+
+// Confidence says how sure a Fingerprint is of a fact
+type Confidence int
+
+const (
+	// ConfidenceNone means the fact couldn't be found from outside
+	ConfidenceNone Confidence = iota
+	// ConfidenceLow is a guess which nothing we saw contradicts
+	ConfidenceLow
+	// ConfidenceMedium fits what we saw, but something else might too
+	ConfidenceMedium
+	// ConfidenceHigh was seen directly
+	ConfidenceHigh
+)
+
+func (c Confidence) String() string {
+	switch c {
+	case ConfidenceLow:
+		return "low"
+	case ConfidenceMedium:
+		return "medium"
+	case ConfidenceHigh:
+		return "high"
+	}
+	return "none"
+}
+
+// Fact says how a Fingerprint fact was found. Facts found from the same
+// queries each count them.
+type Fact struct {
+	Queries    int
+	Confidence Confidence
+}
+
+func (f Fact) String() string {
+	return fmt.Sprintf("%s confidence, %d queries", f.Confidence, f.Queries)
+}
+
+// Fingerprint is what can be told about an encryption oracle from the
+// outside, to choose which attack to run on it
+type Fingerprint struct {
+	// BlockSize is 1 for a stream
+	BlockSize     int
+	BlockSizeFact Fact
+	Mode          Mode
+	ModeFact      Fact
+	// PrefixLen is the length of what the oracle puts before our input,
+	// when PrefixRandom is false
+	PrefixLen    int
+	PrefixRandom bool
+	PrefixFact   Fact
+	// SuffixLen is the length of what the oracle puts after our input
+	SuffixLen  int
+	SuffixFact Fact
+	// Padder is the padding scheme, or nil if the oracle doesn't pad or
+	// PaddingFact has no confidence
+	Padder      Padder
+	PaddingFact Fact
+	// Queries is the total used
+	Queries int
+}
+
+func (fp *Fingerprint) String() string {
+	prefix := fmt.Sprintf("%d", fp.PrefixLen)
+	if fp.PrefixRandom {
+		prefix = "random"
+	}
+	return fmt.Sprintf("block size %d (%s), mode %s (%s), prefix %s (%s), suffix %d (%s), padding %T (%s), %d queries",
+		fp.BlockSize, fp.BlockSizeFact,
+		fp.Mode, fp.ModeFact,
+		prefix, fp.PrefixFact,
+		fp.SuffixLen, fp.SuffixFact,
+		fp.Padder, fp.PaddingFact,
+		fp.Queries)
+}
+
+// fingerprintSweep is the longest input sent while sizing up an oracle
+const fingerprintSweep = 64
+
+// sweepQueries is the queries spent sending each input length twice
+const sweepQueries = 2 * (fingerprintSweep + 1)
+
+type fingerprinter struct {
+	fp      *Fingerprint
+	oracle  Oracle
+	queries int
+	// sweep holds the ciphertexts for inputs of every length up to
+	// fingerprintSweep, twice over
+	sweep       [2][][]byte
+	lengthsVary bool
+}
+
+// fact makes a Fact from the queries since start
+func (f *fingerprinter) fact(start int, c Confidence) Fact {
+	return Fact{Queries: f.queries - start, Confidence: c}
+}
+
+// Fingerprint works out the block size, mode, prefix, suffix and padding
+// of an encryption oracle, as far as it can, saying how sure it is of
+// each. It only fails if the oracle is unusable.
+func (oracle Oracle) Fingerprint() (*Fingerprint, error) {
+	f := &fingerprinter{fp: &Fingerprint{}}
+	f.oracle = oracle.counting(&f.queries)
+	fp := f.fp
+
+	// Send every input length twice, to see how the ciphertext grows and
+	// whether it changes between calls
+	for r := range f.sweep {
+		for n := 0; n <= fingerprintSweep; n++ {
+			f.sweep[r] = append(f.sweep[r], f.oracle(make([]byte, n)))
+		}
+	}
+	g := 0
+	lengths := make(map[int]bool)
+	for n := range f.sweep[0] {
+		for r := range f.sweep {
+			l := len(f.sweep[r][n])
+			g = gcd(g, l)
+			lengths[l] = true
+		}
+		if len(f.sweep[0][n]) != len(f.sweep[1][n]) {
+			f.lengthsVary = true
+		}
+	}
+	if g == 0 {
+		return nil, errors.New("Oracle returns nothing")
+	}
+	fp.BlockSize = g
+	conf := ConfidenceHigh
+	if len(lengths) < 3 {
+		conf = ConfidenceLow
+	}
+	fp.BlockSizeFact = f.fact(0, conf)
+	fp.PrefixRandom = f.lengthsVary
+	if f.lengthsVary {
+		fp.PrefixFact = f.fact(0, ConfidenceHigh)
+	}
+
+	var err error
+	if g == 1 {
+		f.stream()
+	} else {
+		err = f.block()
+	}
+	fp.Queries = f.queries
+	if err != nil {
+		return nil, err
+	}
+	return fp, nil
+}
+
+// repeatable says whether the two sweeps agree, apart from perhaps their
+// last blocks, and whether the last blocks ever differed. A ciphertext
+// shorter than a block is all last block.
+func (f *fingerprinter) repeatable() (bool, bool) {
+	bs := f.fp.BlockSize
+	lastVaries := false
+	for n := range f.sweep[0] {
+		a, b := f.sweep[0][n], f.sweep[1][n]
+		if len(a) != len(b) {
+			return false, false
+		}
+		k := len(a) - bs
+		if k < 0 {
+			k = 0
+		}
+		if !BytesEqual(a[:k], b[:k]) {
+			return false, false
+		}
+		if !BytesEqual(a[k:], b[k:]) {
+			lastVaries = true
+		}
+	}
+	return true, lastVaries
+}
+
+// stream grows a byte at a time, so has nothing to pad. If it repeats
+// itself, the first byte our input changes is the end of the prefix.
+func (f *fingerprinter) stream() {
+	fp := f.fp
+	fp.Mode = ModeStream
+	fp.ModeFact = f.fact(0, ConfidenceHigh)
+	fp.PaddingFact = f.fact(0, ConfidenceHigh)
+
+	// A stream's last block is its last byte, so that must repeat too
+	same, lastVaries := f.repeatable()
+	if f.lengthsVary || !same || lastVaries {
+		return
+	}
+	start := f.queries
+	a, b := f.oracle([]byte{0}), f.oracle([]byte{1})
+	for i := range a {
+		if a[i] != b[i] {
+			fp.PrefixLen = i
+			fp.PrefixFact = f.fact(start, ConfidenceHigh)
+			fp.SuffixLen = len(f.sweep[0][0]) - i
+			fp.SuffixFact = Fact{Queries: sweepQueries + fp.PrefixFact.Queries, Confidence: ConfidenceHigh}
+			return
+		}
+	}
+}
+
+func (f *fingerprinter) block() error {
+	fp := f.fp
+	bs := fp.BlockSize
+
+	// Whatever the prefix, four blocks of zeros give at least three
+	// aligned zero blocks
+	start := f.queries
+	ecb := 0
+	for i := 0; i < 3; i++ {
+		if HasDuplicateBlocks(f.oracle(make([]byte, 4*bs)), bs) {
+			ecb++
+		}
+	}
+	switch ecb {
+	case 3:
+		fp.Mode = ModeECB
+		fp.ModeFact = f.fact(start, ConfidenceHigh)
+		return f.ecb()
+	case 0:
+		fp.Mode = ModeCBC
+		fp.ModeFact = f.fact(start, ConfidenceMedium)
+		f.cbc()
+		return nil
+	default:
+		// Changing mode between calls. Nothing else will hold still.
+		fp.Mode = ModeUnknown
+		fp.ModeFact = f.fact(start, ConfidenceLow)
+		return nil
+	}
+}
+
+// ecb aligns its input like DecryptSuffix, which gives the prefix. The
+// encryption of a full block of padding can then be matched against
+// each padding scheme.
+func (f *fingerprinter) ecb() error {
+	fp := f.fp
+	bs := fp.BlockSize
+
+	start := f.queries
+	s := newSuffixAttack(f.oracle, bs)
+	if err := s.align(); err != nil {
+		return fmt.Errorf("Can't align ECB input: %w", err)
+	}
+	prefixQueries := f.queries - start
+
+	start = f.queries
+	baseLen, n, err := s.growth()
+	if err != nil {
+		return fmt.Errorf("Can't find ECB suffix: %w", err)
+	}
+	// With n bytes of input, if the oracle always pads then the last
+	// block is nothing but padding
+	full, err := s.query(make([]byte, n))
+	if err != nil {
+		return err
+	}
+	again, err := s.query(make([]byte, n))
+	if err != nil {
+		return err
+	}
+	last := full[len(full)-bs:]
+	suffixLen := baseLen - n
+	if !BytesEqual(last, again[len(again)-bs:]) {
+		fp.Padder = ISO10126Padder{}
+	} else {
+		candidates := []Padder{PKCS7Padder{}, ANSIX923Padder{}, ISO7816Padder{}}
+		var msg []byte
+		for _, p := range candidates {
+			msg = append(msg, p.Pad(nil, bs)...)
+		}
+		enc, err := s.query(msg)
+		if err != nil {
+			return err
+		}
+		for i, p := range candidates {
+			if BytesEqual(enc[i*bs:(i+1)*bs], last) {
+				fp.Padder = p
+				break
+			}
+		}
+	}
+	// A suffix ending in 0x80 with zero padding can't be told apart from
+	// one byte shorter with ISO 7816 padding. Either is right.
+	conf := ConfidenceHigh
+	if fp.Padder == nil {
+		// Nothing added to whole blocks, so the input before growth
+		// made one more byte than a whole number of blocks
+		fp.Padder = ZeroPadder{}
+		suffixLen++
+		conf = ConfidenceMedium
+	}
+	fp.SuffixLen = suffixLen
+	fp.SuffixFact = f.fact(start, conf)
+	fp.PaddingFact = f.fact(start, conf)
+
+	// Any query may have found the prefix isn't fixed after all
+	if s.padLen < 0 {
+		conf := ConfidenceHigh
+		if !fp.PrefixRandom {
+			conf = ConfidenceMedium
+		}
+		fp.PrefixRandom = true
+		fp.PrefixFact = Fact{Queries: prefixQueries, Confidence: conf}
+	} else if !fp.PrefixRandom {
+		fp.PrefixLen = s.prefixLen
+		fp.PrefixFact = Fact{Queries: prefixQueries, Confidence: ConfidenceHigh}
+	}
+	return nil
+}
+
+// cbc can only see inside if the oracle repeats itself, ie has a fixed
+// IV. Then changing the first input byte changes the block holding the
+// end of the prefix, until enough filler goes in front of it. The padding
+// is hidden, unless it is random.
+func (f *fingerprinter) cbc() {
+	fp := f.fp
+	bs := fp.BlockSize
+
+	same, lastVaries := f.repeatable()
+	if f.lengthsVary || !same {
+		return
+	}
+	// Only the last block varying is random padding
+	if lastVaries {
+		fp.Padder = ISO10126Padder{}
+		fp.PaddingFact = Fact{Queries: sweepQueries, Confidence: ConfidenceMedium}
+	}
+
+	start := f.queries
+	a, b := f.oracle([]byte{0}), f.oracle([]byte{1})
+	j := -1
+	for i := 0; i+bs <= len(a); i += bs {
+		if !BytesEqual(a[i:i+bs], b[i:i+bs]) {
+			j = i
+			break
+		}
+	}
+	if j < 0 {
+		return
+	}
+	for k := 0; k <= bs; k++ {
+		filler := make([]byte, k+1)
+		a = f.oracle(filler)
+		filler[k] = 1
+		b = f.oracle(filler)
+		if BytesEqual(a[j:j+bs], b[j:j+bs]) {
+			fp.PrefixLen = j + bs - k
+			fp.PrefixFact = f.fact(start, ConfidenceHigh)
+			break
+		}
+	}
+	if fp.PrefixFact.Confidence == ConfidenceNone {
+		return
+	}
+
+	// The sweep shows where padding spills in to a new block. That's only
+	// the suffix length if the padding always adds a byte, as every scheme
+	// but zero padding does.
+	conf := ConfidenceLow
+	if lastVaries {
+		conf = ConfidenceMedium
+	}
+	base := len(f.sweep[0][0])
+	for n, buf := range f.sweep[0] {
+		if len(buf) > base {
+			fp.SuffixLen = base - n - fp.PrefixLen
+			fp.SuffixFact = Fact{
+				Queries:    sweepQueries + fp.PrefixFact.Queries,
+				Confidence: conf,
+			}
+			return
+		}
+	}
+}
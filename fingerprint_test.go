@@ -0,0 +1,117 @@
+package cpals
+
+import (
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	key := RandomKey()
+	prefix := RandomBytes(5)
+	// Not random, since zero padding after a final 0x80 looks like ISO 7816
+	suffix := []byte("twenty bytes suffix.")
+	surround := func(msg []byte) []byte {
+		buf := append([]byte{}, prefix...)
+		buf = append(buf, msg...)
+		return append(buf, suffix...)
+	}
+	ecbWith := func(padder Padder) Oracle {
+		return func(msg []byte) []byte {
+			buf, err := AESECBEncryptWith(key, surround(msg), padder)
+			if err != nil {
+				panic(err.Error())
+			}
+			return buf
+		}
+	}
+	fixedIV := RandomBytes(AESBlockSize)
+
+	const (
+		prefixRandom  = -1
+		prefixUnknown = -2
+	)
+	testCases := []struct {
+		name      string
+		oracle    Oracle
+		blockSize int
+		mode      Mode
+		prefixLen int // or prefixRandom or prefixUnknown
+		suffixLen int // -1 for not known
+		padder    Padder
+	}{
+		{"C12", Oracle(C12EncryptionOracleFunc), 16, ModeECB, 0, 138, PKCS7Padder{}},
+		{"C14", Oracle(C14EncryptionOracleFunc), 16, ModeECB, prefixRandom, 138, PKCS7Padder{}},
+		{"ECB PKCS7", ecbWith(PKCS7Padder{}), 16, ModeECB, 5, 20, PKCS7Padder{}},
+		{"ECB ANSI X.923", ecbWith(ANSIX923Padder{}), 16, ModeECB, 5, 20, ANSIX923Padder{}},
+		{"ECB ISO 7816", ecbWith(ISO7816Padder{}), 16, ModeECB, 5, 20, ISO7816Padder{}},
+		{"ECB ISO 10126", ecbWith(ISO10126Padder{}), 16, ModeECB, 5, 20, ISO10126Padder{}},
+		{"ECB zero", ecbWith(ZeroPadder{}), 16, ModeECB, 5, 20, ZeroPadder{}},
+		// Fixed IV CBC hides which padding it is
+		{"CBC fixed IV", func(msg []byte) []byte {
+			return AESCBCEncrypt(key, fixedIV, surround(msg))
+		}, 16, ModeCBC, 5, 20, nil},
+		{"CBC fixed IV ANSI X.923", func(msg []byte) []byte {
+			buf, err := AESCBCEncryptWith(key, fixedIV, surround(msg), ANSIX923Padder{})
+			if err != nil {
+				panic(err.Error())
+			}
+			return buf
+		}, 16, ModeCBC, 5, 20, nil},
+		{"CBC random IV", func(msg []byte) []byte {
+			return AESCBCEncrypt(key, RandomBytes(AESBlockSize), surround(msg))
+		}, 16, ModeCBC, prefixUnknown, -1, nil},
+		{"CTR", func(msg []byte) []byte {
+			return AESCTR(key, 0, surround(msg))
+		}, 1, ModeStream, 5, 20, nil},
+		{"CTR bare", func(msg []byte) []byte {
+			return AESCTR(key, 0, msg)
+		}, 1, ModeStream, 0, 0, nil},
+	}
+
+	for _, tc := range testCases {
+		fp, err := tc.oracle.Fingerprint()
+		if err != nil {
+			t.Fatalf("%s: Can't fingerprint: %s", tc.name, err)
+		}
+		t.Logf("%s: %s", tc.name, fp)
+		if fp.BlockSize != tc.blockSize || fp.BlockSizeFact.Confidence != ConfidenceHigh {
+			t.Fatalf("%s: block size %d (%s), expected %d", tc.name, fp.BlockSize, fp.BlockSizeFact, tc.blockSize)
+		}
+		if fp.Mode != tc.mode {
+			t.Fatalf("%s: mode %s, expected %s", tc.name, fp.Mode, tc.mode)
+		}
+		switch tc.prefixLen {
+		case prefixRandom:
+			if !fp.PrefixRandom {
+				t.Fatalf("%s: prefix not random", tc.name)
+			}
+		case prefixUnknown:
+			if fp.PrefixFact.Confidence != ConfidenceNone {
+				t.Fatalf("%s: prefix should be unknown: %s", tc.name, fp.PrefixFact)
+			}
+		default:
+			if fp.PrefixFact.Confidence == ConfidenceNone {
+				t.Fatalf("%s: prefix not found", tc.name)
+			}
+			if fp.PrefixRandom || fp.PrefixLen != tc.prefixLen {
+				t.Fatalf("%s: prefix %d, expected %d", tc.name, fp.PrefixLen, tc.prefixLen)
+			}
+		}
+		if tc.suffixLen < 0 {
+			if fp.SuffixFact.Confidence != ConfidenceNone {
+				t.Fatalf("%s: suffix should be unknown: %s", tc.name, fp.SuffixFact)
+			}
+		} else if fp.SuffixLen != tc.suffixLen || fp.SuffixFact.Confidence == ConfidenceNone {
+			t.Fatalf("%s: suffix %d (%s), expected %d", tc.name, fp.SuffixLen, fp.SuffixFact, tc.suffixLen)
+		}
+		if fp.Padder != tc.padder {
+			t.Fatalf("%s: padding %T, expected %T", tc.name, fp.Padder, tc.padder)
+		}
+		if tc.mode == ModeCBC && fp.PaddingFact.Confidence != ConfidenceNone {
+			t.Fatalf("%s: CBC padding should be unknown: %s", tc.name, fp.PaddingFact)
+		}
+		if fp.Queries == 0 {
+			t.Fatalf("%s: no queries counted", tc.name)
+		}
+	}
+	t.Logf("Fingerprinted all oracles")
+}
@@ -0,0 +1,360 @@
+package cpals
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Crib is plaintext known to be at a fixed offset. A negative offset
+// counts back from the end of the message.
+type Crib struct {
+	Offset int
+	Bytes  []byte
+}
+
+func (c Crib) start(msgLen int) int {
+	if c.Offset < 0 {
+		return msgLen + c.Offset
+	}
+	return c.Offset
+}
+
+// PlainTextModel knows more about plaintexts than a Scorer does. It
+// can pin plaintext at known offsets, and provide a scorer suitable for a
+// single key column, where offsets are meaningless.
+type PlainTextModel interface {
+	Scorer
+	KnownPlainText() []Crib
+	ColumnScorer() Scorer
+}
+
+// ByteProfile is a probability distribution over byte values. As a
+// Scorer it gives the mean log10 probability of the bytes.
+type ByteProfile [256]float64
+
+// TrainByteProfile counts bytes in the samples, with add-one smoothing so
+// no byte is impossible
+func TrainByteProfile(samples ...[]byte) ByteProfile {
+	var weights [256]float64
+	for i := range weights {
+		weights[i] = 1
+	}
+	for _, s := range samples {
+		for _, b := range s {
+			weights[b]++
+		}
+	}
+	return NewByteProfile(weights)
+}
+
+// NewByteProfile normalises relative weights into a profile
+func NewByteProfile(weights [256]float64) ByteProfile {
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	var p ByteProfile
+	for i, w := range weights {
+		p[i] = w / total
+	}
+	return p
+}
+
+func (p ByteProfile) Score(msg []byte) float64 {
+	if len(msg) == 0 {
+		return 0
+	}
+	score := 0.0
+	for _, b := range msg {
+		score += math.Log10(p[b])
+	}
+	return score / float64(len(msg))
+}
+
+// divergence is the Kullback-Leibler divergence, in log10 units, of the
+// byte distribution of msg from the profile
+func (p ByteProfile) divergence(msg []byte) float64 {
+	var counts [256]int
+	for _, b := range msg {
+		counts[b]++
+	}
+	d := 0.0
+	for i, c := range counts {
+		if c > 0 {
+			q := float64(c) / float64(len(msg))
+			d += q * math.Log10(q/p[i])
+		}
+	}
+	return d
+}
+
+// FileFormat models a binary file type by its magic numbers and fixed
+// header (and trailer) bytes, plus the byte distribution of its body.
+type FileFormat struct {
+	Name    string
+	Cribs   []Crib
+	Profile ByteProfile
+}
+
+// cribWeight scales the fraction of crib bytes matched, so that it
+// dominates the profile score
+const cribWeight = 10.0
+
+func (f *FileFormat) Score(msg []byte) float64 {
+	return f.Profile.Score(msg) + cribWeight*f.cribMatch(msg)
+}
+
+// cribMatch is the fraction of crib bytes present in msg
+func (f *FileFormat) cribMatch(msg []byte) float64 {
+	total, matched := 0, 0
+	for _, c := range f.Cribs {
+		start := c.start(len(msg))
+		for i, b := range c.Bytes {
+			total++
+			j := start + i
+			if j >= 0 && j < len(msg) && msg[j] == b {
+				matched++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(matched) / float64(total)
+}
+
+// Matches reports whether msg has every crib in place
+func (f *FileFormat) Matches(msg []byte) bool {
+	return f.cribMatch(msg) == 1
+}
+
+// bodyFits says whether the bytes of msg are at least twice as close to
+// the profile as to uniform noise, allowing for the divergence a sample of
+// this size shows by chance. For a compressed format, whose profile is
+// uniform, the bytes just have to look uniform.
+func (f *FileFormat) bodyFits(msg []byte) bool {
+	if len(msg) == 0 {
+		return false
+	}
+	chance := 255 / (2 * float64(len(msg)) * math.Ln10)
+	return 2*f.Profile.divergence(msg)-compressedProfile.divergence(msg) <= 2*chance
+}
+
+func (f *FileFormat) KnownPlainText() []Crib {
+	return f.Cribs
+}
+
+func (f *FileFormat) ColumnScorer() Scorer {
+	return f.Profile
+}
+
+func (f *FileFormat) String() string {
+	return f.Name
+}
+
+// Compressed data is close to uniformly random
+var compressedProfile = NewByteProfile(uniformWeights())
+
+// Executables are mostly zeros, with some small integers, 0xff padding
+// and embedded strings
+var executableProfile = NewByteProfile(func() [256]float64 {
+	w := uniformWeights()
+	w[0x00] = 150
+	w[0xff] = 10
+	for b := 0x01; b < 0x10; b++ {
+		w[b] = 4
+	}
+	for b := 'a'; b <= 'z'; b++ {
+		w[b] = 2
+	}
+	return w
+}())
+
+func uniformWeights() [256]float64 {
+	var w [256]float64
+	for i := range w {
+		w[i] = 1
+	}
+	return w
+}
+
+var PNGFormat = &FileFormat{
+	Name: "PNG",
+	Cribs: []Crib{
+		// Signature, then the IHDR chunk length and type
+		{0, []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")},
+		// Empty IEND chunk and its CRC
+		{-12, []byte("\x00\x00\x00\x00IEND\xaeB`\x82")},
+	},
+	Profile: compressedProfile,
+}
+
+var ZIPFormat = &FileFormat{
+	Name: "ZIP",
+	Cribs: []Crib{
+		{0, []byte("PK\x03\x04")},
+		// End of central directory record for a single-disk archive with
+		// no comment
+		{-22, []byte("PK\x05\x06\x00\x00\x00\x00")},
+	},
+	Profile: compressedProfile,
+}
+
+var GzipFormat = &FileFormat{
+	Name: "gzip",
+	Cribs: []Crib{
+		// Magic and deflate compression method
+		{0, []byte("\x1f\x8b\x08")},
+	},
+	Profile: compressedProfile,
+}
+
+var ELFFormat = &FileFormat{
+	Name: "ELF",
+	Cribs: []Crib{
+		// 64-bit little-endian current-version SysV e_ident, with padding
+		{0, []byte("\x7fELF\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00")},
+	},
+	Profile: executableProfile,
+}
+
+var FileFormats = []*FileFormat{PNGFormat, ZIPFormat, GzipFormat, ELFFormat}
+
+// KeyFromCribs finds the repeating-key bytes implied by cribs. It errors
+// if the cribs imply different values for the same key byte, which means
+// the key size (or the file format) is wrong.
+func KeyFromCribs(ctxt []byte, keySize int, cribs []Crib) ([]byte, []bool, error) {
+	if keySize < 1 {
+		return nil, nil, fmt.Errorf("Bad key size %d", keySize)
+	}
+	key := make([]byte, keySize)
+	known := make([]bool, keySize)
+	for _, c := range cribs {
+		start := c.start(len(ctxt))
+		if start < 0 || start+len(c.Bytes) > len(ctxt) {
+			return nil, nil, fmt.Errorf("Crib at %d len %d outside ciphertext len %d", c.Offset, len(c.Bytes), len(ctxt))
+		}
+		for i, b := range c.Bytes {
+			pos := start + i
+			k := ctxt[pos] ^ b
+			j := pos % keySize
+			if known[j] && key[j] != k {
+				return nil, nil, fmt.Errorf("Cribs conflict at key byte %d for key size %d", j, keySize)
+			}
+			key[j] = k
+			known[j] = true
+		}
+	}
+	return key, known, nil
+}
+
+// cribKeySizes filters key sizes for a model: sizes its cribs contradict
+// are dropped, and sizes the cribs fully determine are moved to the front.
+// Otherwise the order is kept.
+func cribKeySizes(ctxt []byte, keySizes []int, model PlainTextModel) []int {
+	var determined, partial []int
+	for _, keySize := range keySizes {
+		_, known, err := KeyFromCribs(ctxt, keySize, model.KnownPlainText())
+		if err != nil {
+			continue
+		}
+		if countTrue(known) == keySize {
+			determined = append(determined, keySize)
+		} else {
+			partial = append(partial, keySize)
+		}
+	}
+	return append(determined, partial...)
+}
+
+// cribRedundancy is how many crib bytes are checked by other crib bytes
+// for this key size, rather than just determining a key byte. With no
+// redundancy, any key size can make any cribs appear.
+func cribRedundancy(ctxt []byte, keySize int, cribs []Crib) int {
+	_, known, err := KeyFromCribs(ctxt, keySize, cribs)
+	if err != nil {
+		return 0
+	}
+	total := 0
+	for _, c := range cribs {
+		total += len(c.Bytes)
+	}
+	return total - countTrue(known)
+}
+
+func countTrue(bs []bool) int {
+	n := 0
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+// rankWithModel ranks key candidates for each position using the model's
+// column scorer, then pins the positions the cribs determine
+func rankWithModel(ctxt []byte, keySize int, model PlainTextModel, topN int) ([]ByteCandidates, error) {
+	columnScorer := model.ColumnScorer()
+	ranked, err := RankRepeatingKeyXor(ctxt, keySize, columnScorer, topN)
+	if err != nil {
+		return nil, err
+	}
+	key, known, err := KeyFromCribs(ctxt, keySize, model.KnownPlainText())
+	if err != nil {
+		return nil, err
+	}
+	columns := XorKeyColumns(ctxt, keySize)
+	for i := range key {
+		if known[i] {
+			score := columnScorer.Score(XorByte(columns[i], key[i]))
+			ranked[i] = ByteCandidates{{key[i], score}}
+		}
+	}
+	return ranked, nil
+}
+
+// BreakXorFileFormat tries to break repeating-key XOR as each format in
+// turn. Of the formats whose cribs all appear in the recovered plaintext,
+// the one whose cribs overdetermine the key the most wins. Cribs which
+// don't overdetermine it, like a short magic number or a key longer than
+// the header, appear whatever the plaintext. Then the body has to fit the
+// format's profile instead, and the shortest key wins.
+func BreakXorFileFormat(ctxt []byte, formats []*FileFormat, opts RepeatingKeyXorOpts) (*FileFormat, RepeatingKeyXorResult, error) {
+	var bestFormat *FileFormat
+	var bestRes RepeatingKeyXorResult
+	bestRedundancy := 0
+	var lastErr error
+	for _, f := range formats {
+		opts.Scorer = f
+		res, err := BreakRepeatingKeyXor(ctxt, opts)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if !f.Matches(res.PlainText) {
+			continue
+		}
+		redundancy := cribRedundancy(ctxt, len(res.Key), f.Cribs)
+		if redundancy == 0 && !f.bodyFits(res.PlainText) {
+			continue
+		}
+		better := redundancy > bestRedundancy
+		if redundancy == 0 && bestRedundancy == 0 {
+			better = bestFormat == nil || len(res.Key) < len(bestRes.Key)
+		}
+		if better {
+			bestFormat = f
+			bestRes = res
+			bestRedundancy = redundancy
+		}
+	}
+	if bestFormat != nil {
+		return bestFormat, bestRes, nil
+	}
+	if lastErr != nil {
+		return nil, bestRes, fmt.Errorf("No format matched: %w", lastErr)
+	}
+	return nil, bestRes, errors.New("No format matched")
+}
@@ -0,0 +1,137 @@
+package cpals
+
+import (
+	"testing"
+)
+
+func fakePNG(bodyLen int) []byte {
+	buf := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+	buf = append(buf, RandomBytes(bodyLen)...)
+	return append(buf, []byte("\x00\x00\x00\x00IEND\xaeB`\x82")...)
+}
+
+func fakeELF(bodyLen int) []byte {
+	buf := []byte("\x7fELF\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	body := make([]byte, bodyLen)
+	for i := 0; i < len(body); i += 32 {
+		copy(body[i:], []byte{0x48, 0x89, 0xe5, 0x01})
+	}
+	return append(buf, body...)
+}
+
+func TestBreakXorPNG(t *testing.T) {
+	plainText := fakePNG(1000)
+	key := []byte("pngkey!")
+	ctxt := XorKey(plainText, key)
+
+	res, err := BreakRepeatingKeyXor(ctxt, RepeatingKeyXorOpts{Scorer: PNGFormat})
+	if err != nil {
+		t.Fatalf("Can't break: %s", err)
+	}
+	if !BytesEqual(res.Key, key) {
+		t.Fatalf("Got key %s expected %s", EnHex(res.Key), EnHex(key))
+	}
+	t.Logf("Got key [%s]", res.Key)
+}
+
+func TestBreakXorELFLongKey(t *testing.T) {
+	// Key is longer than the known header, so the body profile has to
+	// find the remaining key bytes
+	plainText := fakeELF(4000)
+	key := RandomBytes(24)
+	ctxt := XorKey(plainText, key)
+
+	res, err := BreakRepeatingKeyXor(ctxt, RepeatingKeyXorOpts{Scorer: ELFFormat})
+	if err != nil {
+		t.Fatalf("Can't break: %s", err)
+	}
+	if !BytesEqual(res.Key, key) {
+		t.Fatalf("Got key %s expected %s", EnHex(res.Key), EnHex(key))
+	}
+}
+
+func TestSolveSingleByteXorGzip(t *testing.T) {
+	plainText := append([]byte("\x1f\x8b\x08\x00"), RandomBytes(200)...)
+	key := byte(0xa5)
+	_, _, b := SolveSingleByteXor(XorByte(plainText, key), GzipFormat)
+	if b != key {
+		t.Fatalf("Got key %02X expected %02X", b, key)
+	}
+}
+
+func TestBreakXorFileFormat(t *testing.T) {
+	plainText := []byte("PK\x03\x04")
+	plainText = append(plainText, RandomBytes(500)...)
+	plainText = append(plainText, []byte("PK\x05\x06\x00\x00\x00\x00")...)
+	plainText = append(plainText, RandomBytes(14)...)
+	key := []byte("zip")
+	ctxt := XorKey(plainText, key)
+
+	f, res, err := BreakXorFileFormat(ctxt, FileFormats, RepeatingKeyXorOpts{})
+	if err != nil {
+		t.Fatalf("Can't break: %s", err)
+	}
+	if f != ZIPFormat {
+		t.Fatalf("Got format %s expected %s", f, ZIPFormat)
+	}
+	if !BytesEqual(res.Key, key) {
+		t.Fatalf("Got key %s expected %s", EnHex(res.Key), EnHex(key))
+	}
+
+	_, _, err = BreakXorFileFormat(XorKey(Hamlet, key), FileFormats, RepeatingKeyXorOpts{})
+	if err == nil {
+		t.Fatalf("Matched a format for Hamlet")
+	}
+	t.Logf("Hamlet errored ok: %s", err)
+}
+
+func TestKeyFromCribs(t *testing.T) {
+	ctxt := XorKey([]byte("ABCDABCD"), []byte{1, 2, 3})
+	cribs := []Crib{{0, []byte("AB")}, {-2, []byte("CD")}}
+	key, known, err := KeyFromCribs(ctxt, 3, cribs)
+	if err != nil {
+		t.Fatalf("Can't get key: %s", err)
+	}
+	// Positions 0, 1, 6, 7 give key bytes 0, 1, 0, 1
+	if !known[0] || !known[1] || known[2] {
+		t.Fatalf("Wrong known positions %v", known)
+	}
+	if key[0] != 1 || key[1] != 2 {
+		t.Fatalf("Wrong key %v", key)
+	}
+
+	_, _, err = KeyFromCribs(ctxt, 3, []Crib{{0, []byte("AB")}, {3, []byte("XX")}})
+	if err == nil {
+		t.Fatalf("Didn't error on conflicting cribs")
+	}
+	t.Logf("Conflicting cribs errored ok: %s", err)
+}
+
+func TestBreakXorFileFormatNoRedundancy(t *testing.T) {
+	// Three bytes of magic never overdetermine a key
+	plainText := append([]byte("\x1f\x8b\x08\x00"), RandomBytes(1000)...)
+	key := []byte("gz!")
+	f, res, err := BreakXorFileFormat(XorKey(plainText, key), FileFormats, RepeatingKeyXorOpts{})
+	if err != nil {
+		t.Fatalf("Can't break gzip: %s", err)
+	}
+	if f != GzipFormat {
+		t.Fatalf("Got format %s expected %s", f, GzipFormat)
+	}
+	if !BytesEqual(res.Key, key) {
+		t.Fatalf("Got key %s expected %s", EnHex(res.Key), EnHex(key))
+	}
+
+	// Nor does a key longer than the header
+	key = RandomBytes(24)
+	f, res, err = BreakXorFileFormat(XorKey(fakeELF(4000), key), FileFormats, RepeatingKeyXorOpts{})
+	if err != nil {
+		t.Fatalf("Can't break ELF: %s", err)
+	}
+	if f != ELFFormat {
+		t.Fatalf("Got format %s expected %s", f, ELFFormat)
+	}
+	if !BytesEqual(res.Key, key) {
+		t.Fatalf("Got key %s expected %s", EnHex(res.Key), EnHex(key))
+	}
+}
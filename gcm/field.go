@@ -0,0 +1,114 @@
+package gcm
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// Element is an element of GF(2^128) as GCM uses it. GCM puts the
+// coefficient of x^0 in the most significant bit of the first byte, so hi
+// holds x^0 to x^63 from its top bit down, and lo x^64 to x^127.
+type Element struct {
+	hi, lo uint64
+}
+
+var (
+	Zero = Element{}
+	One  = Element{hi: 1 << 63}
+)
+
+// ElementFromBytes reads a 16 byte block as an element
+func ElementFromBytes(b []byte) Element {
+	return Element{
+		hi: binary.BigEndian.Uint64(b),
+		lo: binary.BigEndian.Uint64(b[8:]),
+	}
+}
+
+// Bytes writes the element as a 16 byte block
+func (e Element) Bytes() []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, e.hi)
+	binary.BigEndian.PutUint64(b[8:], e.lo)
+	return b
+}
+
+func (e Element) String() string {
+	return hex.EncodeToString(e.Bytes())
+}
+
+func (e Element) IsZero() bool {
+	return e == Zero
+}
+
+// Add is XOR, and so also subtraction
+func (e Element) Add(f Element) Element {
+	return Element{hi: e.hi ^ f.hi, lo: e.lo ^ f.lo}
+}
+
+// Mul multiplies modulo x^128 + x^7 + x^2 + x + 1, as in SP800-38D
+// algorithm 1
+func (e Element) Mul(f Element) Element {
+	var z Element
+	v := f
+	for i := 0; i < 128; i++ {
+		bit := e.hi >> uint(63-i)
+		if i >= 64 {
+			bit = e.lo >> uint(127-i)
+		}
+		if bit&1 != 0 {
+			z = z.Add(v)
+		}
+		v = v.mulX()
+	}
+	return z
+}
+
+// mulX multiplies by x, which shifts towards the low order end of the
+// block and reduces whatever falls off the x^127 end
+func (e Element) mulX() Element {
+	carry := e.lo & 1
+	e.lo = e.lo>>1 | e.hi<<63
+	e.hi >>= 1
+	if carry != 0 {
+		// x^128 = x^7 + x^2 + x + 1
+		e.hi ^= 0xe1 << 56
+	}
+	return e
+}
+
+func (e Element) Square() Element {
+	return e.Mul(e)
+}
+
+// Pow raises to the power n
+func (e Element) Pow(n uint64) Element {
+	r := One
+	for ; n > 0; n >>= 1 {
+		if n&1 != 0 {
+			r = r.Mul(e)
+		}
+		e = e.Square()
+	}
+	return r
+}
+
+// Sqrt is the square root, e^(2^127). Squaring is a bijection in
+// characteristic 2, so every element has exactly one.
+func (e Element) Sqrt() Element {
+	for i := 0; i < 127; i++ {
+		e = e.Square()
+	}
+	return e
+}
+
+// Inverse is the multiplicative inverse, e^(2^128-2). Zero has none, and
+// maps to zero.
+func (e Element) Inverse() Element {
+	// 2^128 - 2 is 127 ones then a zero
+	r := One
+	for i := 0; i < 127; i++ {
+		r = r.Mul(e).Square()
+	}
+	return r
+}
@@ -0,0 +1,128 @@
+package gcm
+
+import (
+	"crypto/aes"
+	"errors"
+	"fmt"
+
+	"github.com/jbert/cpals-go"
+)
+
+// Sealed is a GCM ciphertext split in to its parts
+type Sealed struct {
+	AdditionalData []byte
+	Ciphertext     []byte
+	Tag            []byte
+}
+
+// SplitSealed splits the output of Seal
+func SplitSealed(sealed, additionalData []byte, tagSize int) (Sealed, error) {
+	if len(sealed) < tagSize {
+		return Sealed{}, fmt.Errorf("Sealed len %d shorter than tag %d", len(sealed), tagSize)
+	}
+	n := len(sealed) - tagSize
+	return Sealed{AdditionalData: additionalData, Ciphertext: sealed[:n], Tag: sealed[n:]}, nil
+}
+
+// Join puts the ciphertext and tag back together, as Open takes them
+func (s Sealed) Join() []byte {
+	buf := make([]byte, 0, len(s.Ciphertext)+len(s.Tag))
+	buf = append(buf, s.Ciphertext...)
+	return append(buf, s.Tag...)
+}
+
+// TagPoly is GHASH as a polynomial in H, plus the tag. It has the same
+// value, E(J0), at the real H for every message sealed with one nonce.
+func TagPoly(s Sealed) Poly {
+	var blocks []Element
+	for _, buf := range [][]byte{s.AdditionalData, s.Ciphertext} {
+		for i := 0; i < len(buf); i += BlockSize {
+			block := make([]byte, BlockSize)
+			copy(block, buf[i:])
+			blocks = append(blocks, ElementFromBytes(block))
+		}
+	}
+	blocks = append(blocks, LengthBlock(len(s.AdditionalData), len(s.Ciphertext)))
+
+	// The first block has the highest power, the last is times H
+	p := make(Poly, len(blocks)+1)
+	p[0] = ElementFromBytes(s.Tag)
+	for i, b := range blocks {
+		p[len(blocks)-i] = b
+	}
+	return p.trim()
+}
+
+// Candidate is a possible authentication key, with what it implies about
+// the nonce the messages shared
+type Candidate struct {
+	H Element
+	// mask is E(J0), which hides GHASH in the tag
+	mask Element
+}
+
+// Forge computes the tag for any ciphertext under the shared nonce, if the
+// candidate is the real H
+func (c Candidate) Forge(additionalData, ciphertext []byte) Sealed {
+	tag := GHASH(c.H, additionalData, ciphertext).Add(c.mask)
+	return Sealed{AdditionalData: additionalData, Ciphertext: ciphertext, Tag: tag.Bytes()}
+}
+
+// ForbiddenAttack recovers the authentication key from two messages sealed
+// with the same nonce and full length tags. Subtracting the tag
+// polynomials cancels the mask, leaving a polynomial with H as a root.
+// Other roots come along too; a third message with the same nonce, or
+// trying each forgery, tells them apart.
+func ForbiddenAttack(a, b Sealed) ([]Candidate, error) {
+	if len(a.Tag) != BlockSize || len(b.Tag) != BlockSize {
+		return nil, errors.New("Need full length tags")
+	}
+	pa := TagPoly(a)
+	p := pa.Add(TagPoly(b))
+	if p.Degree() < 1 {
+		return nil, errors.New("Messages are the same")
+	}
+	var candidates []Candidate
+	for _, h := range p.Roots() {
+		// GHASH plus the tag is the mask
+		mask := pa.Eval(h)
+		candidates = append(candidates, Candidate{H: h, mask: mask})
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("No roots found: nonces weren't reused")
+	}
+	return candidates, nil
+}
+
+// NonceReuseService seals messages under a fixed key, and wrongly seals
+// every one with the same nonce
+type NonceReuseService struct {
+	g     *GCM
+	nonce []byte
+}
+
+func NewNonceReuseService() *NonceReuseService {
+	block, err := aes.NewCipher(cpals.RandomKey())
+	if err != nil {
+		panic(fmt.Sprintf("Can't create aes cipher: %s", err))
+	}
+	g, err := New(block, StandardTagSize)
+	if err != nil {
+		panic(fmt.Sprintf("Can't create GCM: %s", err))
+	}
+	return &NonceReuseService{g: g, nonce: cpals.RandomBytes(StandardNonceSize)}
+}
+
+func (s *NonceReuseService) Seal(msg, additionalData []byte) Sealed {
+	sealed, _ := SplitSealed(s.g.Seal(nil, s.nonce, msg, additionalData), additionalData, StandardTagSize)
+	return sealed
+}
+
+func (s *NonceReuseService) Open(sealed Sealed) ([]byte, error) {
+	return s.g.Open(nil, s.nonce, sealed.Join(), sealed.AdditionalData)
+}
+
+// IsH lets tests check the attack's answer
+func (s *NonceReuseService) IsH(h Element) bool {
+	return s.g.H() == h
+}
@@ -0,0 +1,161 @@
+// Package gcm implements Galois/Counter Mode as defined in NIST SP800-38D,
+// with its insides on show.
+//
+// Unlike crypto/cipher's GCM, the authentication key H, GHASH and the
+// GF(2^128) arithmetic are all exported, and any nonce length and any tag
+// length from 1 to 16 bytes can be used, so that truncated tags and
+// non-standard nonces can be attacked.
+//
+// It is slow and not constant time. Don't use it for anything real.
+package gcm
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/jbert/cpals-go"
+)
+
+const (
+	BlockSize = 16
+	// StandardNonceSize is the nonce length GCM is designed around
+	StandardNonceSize = 12
+	// StandardTagSize is the full tag length
+	StandardTagSize = 16
+)
+
+// GCM is an AEAD built on a 128 bit block cipher
+type GCM struct {
+	b       cipher.Block
+	h       Element
+	tagSize int
+}
+
+// New creates a GCM with a tag of tagSize bytes
+func New(b cipher.Block, tagSize int) (*GCM, error) {
+	if b.BlockSize() != BlockSize {
+		return nil, fmt.Errorf("GCM needs a %d byte block cipher, not %d", BlockSize, b.BlockSize())
+	}
+	if tagSize < 1 || tagSize > StandardTagSize {
+		return nil, fmt.Errorf("Tag size must be 1 to %d bytes, not %d", StandardTagSize, tagSize)
+	}
+	h := make([]byte, BlockSize)
+	b.Encrypt(h, h)
+	return &GCM{b: b, h: ElementFromBytes(h), tagSize: tagSize}, nil
+}
+
+// H is the authentication key, the encryption of the zero block
+func (g *GCM) H() Element {
+	return g.h
+}
+
+// NonceSize is the standard nonce size, but Seal and Open take any
+// non-empty nonce
+func (g *GCM) NonceSize() int {
+	return StandardNonceSize
+}
+
+func (g *GCM) Overhead() int {
+	return g.tagSize
+}
+
+// GHASH hashes the additional data and ciphertext, each zero padded to a
+// whole block, then a block of their lengths in bits. It is the polynomial
+// with those blocks as coefficients, evaluated at h.
+func GHASH(h Element, additionalData, ciphertext []byte) Element {
+	var y Element
+	y = ghashBlocks(h, y, additionalData)
+	y = ghashBlocks(h, y, ciphertext)
+	y = y.Add(LengthBlock(len(additionalData), len(ciphertext)))
+	return y.Mul(h)
+}
+
+// ghashBlocks folds the zero padded blocks of buf in to y
+func ghashBlocks(h, y Element, buf []byte) Element {
+	block := make([]byte, BlockSize)
+	for len(buf) > 0 {
+		for i := range block {
+			block[i] = 0
+		}
+		n := copy(block, buf)
+		buf = buf[n:]
+		y = y.Add(ElementFromBytes(block)).Mul(h)
+	}
+	return y
+}
+
+// LengthBlock is the last GHASH block, the lengths in bits of the
+// additional data and ciphertext
+func LengthBlock(additionalDataLen, ciphertextLen int) Element {
+	return Element{hi: uint64(additionalDataLen) * 8, lo: uint64(ciphertextLen) * 8}
+}
+
+// Counter0 is the first counter block, J0. A 12 byte nonce is used
+// directly; anything else is hashed.
+func (g *GCM) Counter0(nonce []byte) []byte {
+	if len(nonce) == StandardNonceSize {
+		j0 := make([]byte, BlockSize)
+		copy(j0, nonce)
+		j0[BlockSize-1] = 1
+		return j0
+	}
+	return GHASH(g.h, nil, nonce).Bytes()
+}
+
+// crypt runs CTR from the counter after j0
+func (g *GCM) crypt(j0, dst, src []byte) {
+	iv := make([]byte, BlockSize)
+	copy(iv, j0)
+	binary.BigEndian.PutUint32(iv[12:], binary.BigEndian.Uint32(iv[12:])+1)
+	ctr, err := cpals.NewCTR(g.b, iv, cpals.GCMCTRLayout)
+	if err != nil {
+		panic(fmt.Sprintf("Internal error creating CTR: %s", err))
+	}
+	ctr.XORKeyStream(dst, src)
+}
+
+// Tag computes the (truncated) tag for a ciphertext
+func (g *GCM) Tag(nonce, ciphertext, additionalData []byte) []byte {
+	s := GHASH(g.h, additionalData, ciphertext).Bytes()
+	tag := make([]byte, BlockSize)
+	g.b.Encrypt(tag, g.Counter0(nonce))
+	for i := range tag {
+		tag[i] ^= s[i]
+	}
+	return tag[:g.tagSize]
+}
+
+// Seal encrypts and authenticates plaintext, appending the ciphertext and
+// tag to dst
+func (g *GCM) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) == 0 {
+		panic("gcm: empty nonce")
+	}
+	ctxt := make([]byte, len(plaintext))
+	g.crypt(g.Counter0(nonce), ctxt, plaintext)
+	dst = append(dst, ctxt...)
+	return append(dst, g.Tag(nonce, ctxt, additionalData)...)
+}
+
+var ErrOpen = errors.New("gcm: message authentication failed")
+
+// Open checks the tag and decrypts, appending the plaintext to dst
+func (g *GCM) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) == 0 {
+		return nil, errors.New("gcm: empty nonce")
+	}
+	if len(ciphertext) < g.tagSize {
+		return nil, ErrOpen
+	}
+	tag := ciphertext[len(ciphertext)-g.tagSize:]
+	ciphertext = ciphertext[:len(ciphertext)-g.tagSize]
+	if subtle.ConstantTimeCompare(tag, g.Tag(nonce, ciphertext, additionalData)) != 1 {
+		return nil, ErrOpen
+	}
+	msg := make([]byte, len(ciphertext))
+	g.crypt(g.Counter0(nonce), msg, ciphertext)
+	return append(dst, msg...), nil
+}
@@ -0,0 +1,174 @@
+package gcm
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"testing"
+
+	"github.com/jbert/cpals-go"
+)
+
+func mustDeHex(t *testing.T, h string) []byte {
+	buf, err := hex.DecodeString(h)
+	if err != nil {
+		t.Fatalf("Bad hex [%s]: %s", h, err)
+	}
+	return buf
+}
+
+// Test cases 1-6 from McGrew and Viega, "The Galois/Counter Mode of
+// Operation (GCM)", as used by the NIST validation suite
+var (
+	tcKey = "feffe9928665731c6d6a8f9467308308"
+	tcMsg = "d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a72" +
+		"1c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b391aafd255"
+	tcAAD = "feedfacedeadbeeffeedfacedeadbeefabaddad2"
+)
+
+var knownAnswers = []struct {
+	name       string
+	key, nonce string
+	msg, aad   string
+	ctxt, tag  string
+	h          string
+}{
+	{"TC1", "00000000000000000000000000000000", "000000000000000000000000", "", "",
+		"", "58e2fccefa7e3061367f1d57a4e7455a", "66e94bd4ef8a2c3b884cfa59ca342b2e"},
+	{"TC2", "00000000000000000000000000000000", "000000000000000000000000", "00000000000000000000000000000000", "",
+		"0388dace60b6a392f328c2b971b2fe78", "ab6e47d42cec13bdf53a67b21257bddf", "66e94bd4ef8a2c3b884cfa59ca342b2e"},
+	{"TC3", tcKey, "cafebabefacedbaddecaf888", tcMsg, "",
+		"42831ec2217774244b7221b784d0d49ce3aa212f2c02a4e035c17e2329aca12e" +
+			"21d514b25466931c7d8f6a5aac84aa051ba30b396a0aac973d58e091473f5985",
+		"4d5c2af327cd64a62cf35abd2ba6fab4", "b83b533708bf535d0aa6e52980d53b78"},
+	{"TC4", tcKey, "cafebabefacedbaddecaf888", tcMsg[:120], tcAAD,
+		"42831ec2217774244b7221b784d0d49ce3aa212f2c02a4e035c17e2329aca12e" +
+			"21d514b25466931c7d8f6a5aac84aa051ba30b396a0aac973d58e091",
+		"5bc94fbc3221a5db94fae95ae7121a47", "b83b533708bf535d0aa6e52980d53b78"},
+	// 8 byte nonce
+	{"TC5", tcKey, "cafebabefacedbad", tcMsg[:120], tcAAD,
+		"61353b4c2806934a777ff51fa22a4755699b2a714fcdc6f83766e5f97b6c7423" +
+			"73806900e49f24b22b097544d4896b424989b5e1ebac0f07c23f4598",
+		"3612d2e79e3b0785561be14aaca2fccb", "b83b533708bf535d0aa6e52980d53b78"},
+	// 60 byte nonce
+	{"TC6", tcKey, "9313225df88406e555909c5aff5269aa6a7a9538534f7da1e4c303d2a318a728" +
+		"c3c0c95156809539fcf0e2429a6b525416aedbf5a0de6a57a637b39b", tcMsg[:120], tcAAD,
+		"8ce24998625615b603a033aca13fb894be9112a5c3a211a8ba262a3cca7e2ca7" +
+			"01e4a9a4fba43c90ccdcb281d48c7c6fd62875d2aca417034c34aee5",
+		"619cc5aefffe0bfa462af43c1699d050", "b83b533708bf535d0aa6e52980d53b78"},
+}
+
+func TestKnownAnswers(t *testing.T) {
+	for _, ka := range knownAnswers {
+		block, _ := aes.NewCipher(mustDeHex(t, ka.key))
+		g, err := New(block, StandardTagSize)
+		if err != nil {
+			t.Fatalf("%s: can't create GCM: %s", ka.name, err)
+		}
+		if g.H().String() != ka.h {
+			t.Fatalf("%s: H is %s expected %s", ka.name, g.H(), ka.h)
+		}
+		nonce := mustDeHex(t, ka.nonce)
+		aad := mustDeHex(t, ka.aad)
+		msg := mustDeHex(t, ka.msg)
+		sealed := g.Seal(nil, nonce, msg, aad)
+		expected := ka.ctxt + ka.tag
+		if hex.EncodeToString(sealed) != expected {
+			t.Fatalf("%s: got %x expected %s", ka.name, sealed, expected)
+		}
+		got, err := g.Open(nil, nonce, sealed, aad)
+		if err != nil || !cpals.BytesEqual(got, msg) {
+			t.Fatalf("%s: can't open: %s", ka.name, err)
+		}
+	}
+}
+
+func TestMatchesStdlib(t *testing.T) {
+	block, _ := aes.NewCipher(cpals.RandomKey())
+	msg := cpals.RandomBytes(100)
+	aad := cpals.RandomBytes(33)
+	for _, nonceSize := range []int{12, 1, 16, 64} {
+		for _, tagSize := range []int{16, 12} {
+			var std cipher.AEAD
+			if nonceSize == StandardNonceSize {
+				std, _ = cipher.NewGCMWithTagSize(block, tagSize)
+			} else if tagSize == StandardTagSize {
+				std, _ = cipher.NewGCMWithNonceSize(block, nonceSize)
+			} else {
+				// The stdlib won't do both at once
+				continue
+			}
+			nonce := cpals.RandomBytes(nonceSize)
+			g, _ := New(block, tagSize)
+			got := g.Seal(nil, nonce, msg, aad)
+			expected := std.Seal(nil, nonce, msg, aad)
+			if !cpals.BytesEqual(got, expected) {
+				t.Fatalf("nonce %d tag %d: got %x expected %x", nonceSize, tagSize, got, expected)
+			}
+		}
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	block, _ := aes.NewCipher(cpals.RandomKey())
+	// A 4 byte tag is easy to study, and easy to forge
+	g, _ := New(block, 4)
+	nonce := cpals.RandomBytes(StandardNonceSize)
+	sealed := g.Seal(nil, nonce, []byte("attack at dawn"), []byte("header"))
+	if len(sealed) != 14+4 {
+		t.Fatalf("Sealed to %d bytes", len(sealed))
+	}
+
+	bad := make([]byte, len(sealed))
+	copy(bad, sealed)
+	bad[0] ^= 1
+	for name, tc := range map[string]struct {
+		sealed, aad []byte
+	}{
+		"flipped":   {bad, []byte("header")},
+		"wrong aad": {sealed, []byte("Header")},
+		"short":     {sealed[:3], []byte("header")},
+	} {
+		_, err := g.Open(nil, nonce, tc.sealed, tc.aad)
+		if err == nil {
+			t.Fatalf("%s: didn't error", name)
+		}
+		t.Logf("%s: errored ok: %s", name, err)
+	}
+
+	for _, tagSize := range []int{0, 17} {
+		_, err := New(block, tagSize)
+		if err == nil {
+			t.Fatalf("Didn't error on tag size %d", tagSize)
+		}
+	}
+}
+
+func TestField(t *testing.T) {
+	a := ElementFromBytes(cpals.RandomBytes(16))
+	b := ElementFromBytes(cpals.RandomBytes(16))
+	c := ElementFromBytes(cpals.RandomBytes(16))
+
+	if a.Mul(b) != b.Mul(a) {
+		t.Fatalf("Mul doesn't commute")
+	}
+	if a.Mul(b.Add(c)) != a.Mul(b).Add(a.Mul(c)) {
+		t.Fatalf("Mul doesn't distribute")
+	}
+	if a.Mul(One) != a || a.Mul(Zero) != Zero {
+		t.Fatalf("Bad identities")
+	}
+	if a.Mul(a.Inverse()) != One {
+		t.Fatalf("Bad inverse %s for %s", a.Inverse(), a)
+	}
+	if a.Pow(3) != a.Mul(a).Mul(a) {
+		t.Fatalf("Bad cube")
+	}
+
+	// GHASH from test case 2
+	h := ElementFromBytes(mustDeHex(t, "66e94bd4ef8a2c3b884cfa59ca342b2e"))
+	s := GHASH(h, nil, mustDeHex(t, "0388dace60b6a392f328c2b971b2fe78"))
+	if s.String() != "f38cbb1ad69223dcc3457ae5b6b0f885" {
+		t.Fatalf("GHASH is %s", s)
+	}
+}
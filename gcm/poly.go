@@ -0,0 +1,343 @@
+package gcm
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jbert/cpals-go"
+)
+
+// Poly is a polynomial over GF(2^128), with the constant term first. The
+// last coefficient is never zero, so the zero polynomial is empty.
+type Poly []Element
+
+// X is the polynomial x
+var X = Poly{Zero, One}
+
+// NewPoly creates a polynomial from its coefficients, constant term first
+func NewPoly(coeffs ...Element) Poly {
+	p := make(Poly, len(coeffs))
+	copy(p, coeffs)
+	return p.trim()
+}
+
+func (p Poly) trim() Poly {
+	for len(p) > 0 && p[len(p)-1].IsZero() {
+		p = p[:len(p)-1]
+	}
+	return p
+}
+
+func (p Poly) copy() Poly {
+	return NewPoly(p...)
+}
+
+// Degree is -1 for the zero polynomial
+func (p Poly) Degree() int {
+	return len(p) - 1
+}
+
+func (p Poly) IsZero() bool {
+	return len(p) == 0
+}
+
+// IsOne is true for the constant polynomial 1
+func (p Poly) IsOne() bool {
+	return len(p) == 1 && p[0] == One
+}
+
+func (p Poly) Equal(q Poly) bool {
+	if len(p) != len(q) {
+		return false
+	}
+	for i := range p {
+		if p[i] != q[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Lead is the coefficient of the highest power
+func (p Poly) Lead() Element {
+	if p.IsZero() {
+		return Zero
+	}
+	return p[len(p)-1]
+}
+
+func (p Poly) String() string {
+	var terms []string
+	for i := len(p) - 1; i >= 0; i-- {
+		if p[i].IsZero() {
+			continue
+		}
+		switch i {
+		case 0:
+			terms = append(terms, p[i].String())
+		case 1:
+			terms = append(terms, p[i].String()+"x")
+		default:
+			terms = append(terms, p[i].String()+"x^"+strconv.Itoa(i))
+		}
+	}
+	if len(terms) == 0 {
+		return "0"
+	}
+	return strings.Join(terms, " + ")
+}
+
+// Add is also subtraction
+func (p Poly) Add(q Poly) Poly {
+	if len(q) > len(p) {
+		p, q = q, p
+	}
+	sum := make(Poly, len(p))
+	copy(sum, p)
+	for i := range q {
+		sum[i] = sum[i].Add(q[i])
+	}
+	return sum.trim()
+}
+
+func (p Poly) Mul(q Poly) Poly {
+	if p.IsZero() || q.IsZero() {
+		return nil
+	}
+	prod := make(Poly, len(p)+len(q)-1)
+	for i := range p {
+		for j := range q {
+			prod[i+j] = prod[i+j].Add(p[i].Mul(q[j]))
+		}
+	}
+	return prod.trim()
+}
+
+// Scale multiplies every coefficient by e
+func (p Poly) Scale(e Element) Poly {
+	scaled := make(Poly, len(p))
+	for i := range p {
+		scaled[i] = p[i].Mul(e)
+	}
+	return scaled.trim()
+}
+
+// Square is cheap in characteristic 2, since the cross terms cancel
+func (p Poly) Square() Poly {
+	if p.IsZero() {
+		return nil
+	}
+	sq := make(Poly, 2*len(p)-1)
+	for i := range p {
+		sq[2*i] = p[i].Square()
+	}
+	return sq
+}
+
+// DivMod divides by d, giving the quotient and remainder. It panics if d
+// is zero.
+func (p Poly) DivMod(d Poly) (Poly, Poly) {
+	if d.IsZero() {
+		panic("gcm: polynomial division by zero")
+	}
+	r := p.copy()
+	if r.Degree() < d.Degree() {
+		return nil, r
+	}
+	q := make(Poly, r.Degree()-d.Degree()+1)
+	inv := d.Lead().Inverse()
+	for r.Degree() >= d.Degree() {
+		shift := r.Degree() - d.Degree()
+		c := r.Lead().Mul(inv)
+		q[shift] = c
+		for i := range d {
+			r[i+shift] = r[i+shift].Add(d[i].Mul(c))
+		}
+		r = r.trim()
+	}
+	return q.trim(), r
+}
+
+func (p Poly) Mod(d Poly) Poly {
+	_, r := p.DivMod(d)
+	return r
+}
+
+// Monic scales so the leading coefficient is one
+func (p Poly) Monic() Poly {
+	if p.IsZero() {
+		return nil
+	}
+	return p.Scale(p.Lead().Inverse())
+}
+
+// GCD is the monic greatest common divisor
+func GCD(a, b Poly) Poly {
+	for !b.IsZero() {
+		a, b = b, a.Mod(b)
+	}
+	return a.Monic()
+}
+
+// Deriv is the formal derivative. In characteristic 2 the even powers
+// vanish.
+func (p Poly) Deriv() Poly {
+	if len(p) < 2 {
+		return nil
+	}
+	d := make(Poly, len(p)-1)
+	for i := 1; i < len(p); i += 2 {
+		d[i-1] = p[i]
+	}
+	return d.trim()
+}
+
+// Sqrt is the square root of a polynomial with only even powers, which is
+// what is left when the derivative is zero
+func (p Poly) Sqrt() Poly {
+	r := make(Poly, (len(p)+1)/2)
+	for i := range r {
+		r[i] = p[2*i].Sqrt()
+	}
+	return r.trim()
+}
+
+// Eval evaluates at x
+func (p Poly) Eval(x Element) Element {
+	var y Element
+	for i := len(p) - 1; i >= 0; i-- {
+		y = y.Mul(x).Add(p[i])
+	}
+	return y
+}
+
+// frobenius raises to the power 2^128 mod m
+func (p Poly) frobenius(m Poly) Poly {
+	for i := 0; i < 128; i++ {
+		p = p.Square().Mod(m)
+	}
+	return p
+}
+
+// Factor is a factor found by factorization. N is its multiplicity from
+// SquareFree, or the degree of its irreducible factors from
+// DistinctDegree.
+type Factor struct {
+	P Poly
+	N int
+}
+
+// SquareFree splits a polynomial in to monic square-free factors, each
+// with its multiplicity
+func (p Poly) SquareFree() []Factor {
+	f := p.Monic()
+	var factors []Factor
+
+	c := GCD(f, f.Deriv())
+	w, _ := f.DivMod(c)
+	for i := 1; w.Degree() > 0; i++ {
+		y := GCD(w, c)
+		fac, _ := w.DivMod(y)
+		if fac.Degree() > 0 {
+			factors = append(factors, Factor{fac, i})
+		}
+		w = y
+		c, _ = c.DivMod(y)
+	}
+	// What is left has a zero derivative, so is a square
+	if c.Degree() > 0 {
+		for _, sub := range c.Sqrt().SquareFree() {
+			factors = append(factors, Factor{sub.P, 2 * sub.N})
+		}
+	}
+	return factors
+}
+
+// DistinctDegree splits a square-free polynomial in to factors which are
+// each a product of irreducibles of the same degree
+func (p Poly) DistinctDegree() []Factor {
+	f := p.Monic()
+	var factors []Factor
+	// h is x^(q^d) mod f, with q = 2^128
+	h := X
+	for d := 1; f.Degree() >= 2*d; d++ {
+		h = h.frobenius(f)
+		g := GCD(f, h.Add(X))
+		if g.Degree() > 0 {
+			factors = append(factors, Factor{g, d})
+			f, _ = f.DivMod(g)
+			h = h.Mod(f)
+		}
+	}
+	if f.Degree() > 0 {
+		factors = append(factors, Factor{f, f.Degree()})
+	}
+	return factors
+}
+
+// EqualDegree splits a product of distinct irreducibles of degree d in to
+// those irreducibles, by Cantor-Zassenhaus. In characteristic 2 we split
+// with the trace map rather than a power.
+func (p Poly) EqualDegree(d int) []Poly {
+	f := p.Monic()
+	n := f.Degree() / d
+	factors := []Poly{f}
+	for len(factors) < n {
+		t := randomPoly(f.Degree()).trace(d, f)
+		var next []Poly
+		for _, u := range factors {
+			if u.Degree() > d {
+				g := GCD(u, t)
+				if g.Degree() > 0 && g.Degree() < u.Degree() {
+					q, _ := u.DivMod(g)
+					next = append(next, g, q)
+					continue
+				}
+			}
+			next = append(next, u)
+		}
+		factors = next
+	}
+	return factors
+}
+
+// trace is p + p^2 + p^4 + ... + p^(2^(128d-1)) mod m. Its roots are half
+// of GF(2^128d), so its gcd with m splits m about half the time.
+func (p Poly) trace(d int, m Poly) Poly {
+	s := p.Mod(m)
+	t := s
+	for j := 1; j < 128*d; j++ {
+		s = s.Square().Mod(m)
+		t = t.Add(s)
+	}
+	return t
+}
+
+// randomPoly is a random polynomial of degree less than n
+func randomPoly(n int) Poly {
+	p := make(Poly, n)
+	for i := range p {
+		p[i] = ElementFromBytes(cpals.RandomBytes(BlockSize))
+	}
+	return p.trim()
+}
+
+// Roots finds every root, each once
+func (p Poly) Roots() []Element {
+	if p.Degree() < 1 {
+		return nil
+	}
+	var roots []Element
+	for _, sf := range p.SquareFree() {
+		for _, dd := range sf.P.DistinctDegree() {
+			if dd.N != 1 {
+				continue
+			}
+			for _, linear := range dd.P.EqualDegree(1) {
+				// x + c has root c
+				roots = append(roots, linear[0])
+			}
+		}
+	}
+	return roots
+}
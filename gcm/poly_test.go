@@ -0,0 +1,132 @@
+package gcm
+
+import (
+	"testing"
+
+	"github.com/jbert/cpals-go"
+)
+
+func randomElement() Element {
+	return ElementFromBytes(cpals.RandomBytes(BlockSize))
+}
+
+func TestPolyArithmetic(t *testing.T) {
+	a := randomPoly(5)
+	b := randomPoly(3)
+	q, r := a.Mul(b).Add(X).DivMod(b)
+	if !q.Mul(b).Add(r).Equal(a.Mul(b).Add(X)) || r.Degree() >= b.Degree() {
+		t.Fatalf("Bad division")
+	}
+	if !a.Mul(b).Mod(b).IsZero() {
+		t.Fatalf("Product not divisible")
+	}
+
+	c := randomPoly(3).Monic()
+	g := GCD(a.Mul(c), b.Mul(c))
+	if g.Degree() < 2 || !g.Mod(c).IsZero() {
+		t.Fatalf("GCD %s doesn't include %s", g, c)
+	}
+
+	if !a.Square().Equal(a.Mul(a)) {
+		t.Fatalf("Bad square")
+	}
+	if !a.Square().Sqrt().Equal(a) {
+		t.Fatalf("Bad sqrt")
+	}
+	x := randomElement()
+	if a.Mul(b).Eval(x) != a.Eval(x).Mul(b.Eval(x)) {
+		t.Fatalf("Bad eval")
+	}
+}
+
+func TestFactorization(t *testing.T) {
+	// (x + r1)^3 (x + r2) (x + r3)^2 times an irreducible quadratic
+	r1, r2, r3 := randomElement(), randomElement(), randomElement()
+	l1 := NewPoly(r1, One)
+	l2 := NewPoly(r2, One)
+	l3 := NewPoly(r3, One)
+	var quad Poly
+	for {
+		quad = NewPoly(randomElement(), randomElement(), One)
+		if len(quad.Roots()) == 0 {
+			break
+		}
+	}
+	f := l1.Mul(l1).Mul(l1).Mul(l2).Mul(l3).Mul(l3).Mul(quad)
+
+	multiplicity := map[int]int{}
+	for _, sf := range f.SquareFree() {
+		multiplicity[sf.N] += sf.P.Degree()
+	}
+	// x + r2 and the quadratic once, x + r3 twice, x + r1 three times
+	if multiplicity[1] != 3 || multiplicity[2] != 1 || multiplicity[3] != 1 {
+		t.Fatalf("Bad square-free factorization %v", multiplicity)
+	}
+
+	dd := l1.Mul(l2).Mul(quad).DistinctDegree()
+	if len(dd) != 2 || dd[0].N != 1 || dd[0].P.Degree() != 2 || dd[1].N != 2 {
+		t.Fatalf("Bad distinct degree factorization %v", dd)
+	}
+
+	roots := f.Roots()
+	if len(roots) != 3 {
+		t.Fatalf("Found %d roots", len(roots))
+	}
+	for _, r := range roots {
+		if r != r1 && r != r2 && r != r3 {
+			t.Fatalf("Found non-root %s", r)
+		}
+		if !f.Eval(r).IsZero() {
+			t.Fatalf("Root %s doesn't evaluate to zero", r)
+		}
+	}
+}
+
+func TestForbiddenAttack(t *testing.T) {
+	s := NewNonceReuseService()
+
+	// We know what the first message says
+	known := []byte("user=alice;admin=false;expires=2030-01-01")
+	aad := []byte("v1")
+	a := s.Seal(known, aad)
+	b := s.Seal([]byte("Something else entirely, of a different length"), []byte("v1 header"))
+
+	candidates, err := ForbiddenAttack(a, b)
+	if err != nil {
+		t.Fatalf("Can't attack: %s", err)
+	}
+	found := false
+	for _, c := range candidates {
+		if s.IsH(c.H) {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("H not among %d candidates", len(candidates))
+	}
+	t.Logf("Found H among %d candidates", len(candidates))
+
+	// Flip the known plaintext to what we want, and forge a tag for it
+	wanted := []byte("user=alice;admin=true;;expires=2030-01-01")
+	ctxt, _ := cpals.Xor(a.Ciphertext, known)
+	ctxt, _ = cpals.Xor(ctxt, wanted)
+	forged := false
+	for _, c := range candidates {
+		msg, err := s.Open(c.Forge([]byte("v2"), ctxt))
+		if err != nil {
+			continue
+		}
+		if string(msg) != string(wanted) {
+			t.Fatalf("Forgery opened to %s", msg)
+		}
+		forged = true
+	}
+	if !forged {
+		t.Fatalf("No candidate could forge")
+	}
+
+	_, err = ForbiddenAttack(a, a)
+	if err == nil {
+		t.Fatalf("Didn't error on the same message twice")
+	}
+}
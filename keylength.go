@@ -0,0 +1,238 @@
+package cpals
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+type KeyLengthScore struct {
+	KeySize int
+	Score   float64
+}
+
+// KeyLengthEstimator scores repeating-key sizes for a ciphertext. Results
+// are sorted most likely first. Only the relative order of scores from
+// the same estimator is meaningful.
+type KeyLengthEstimator interface {
+	EstimateKeyLength(buf []byte, minKeySize, maxKeySize int) ([]KeyLengthScore, error)
+}
+
+// RankKeyLengths is a convenience for when only the order matters
+func RankKeyLengths(e KeyLengthEstimator, buf []byte, minKeySize, maxKeySize int) ([]int, error) {
+	scores, err := e.EstimateKeyLength(buf, minKeySize, maxKeySize)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make([]int, len(scores))
+	for i := range scores {
+		sizes[i] = scores[i].KeySize
+	}
+	return sizes, nil
+}
+
+func checkKeySizeRange(minKeySize, maxKeySize int) error {
+	if minKeySize < 1 || maxKeySize < minKeySize {
+		return fmt.Errorf("Bad key size range %d..%d", minKeySize, maxKeySize)
+	}
+	return nil
+}
+
+func sortKeyLengthScores(scores []KeyLengthScore) {
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].Score > scores[j].Score
+	})
+}
+
+// HammingEstimator prefers key sizes where chunks are close in Hamming
+// distance. Score is the negated normalised distance.
+type HammingEstimator struct{}
+
+func (e HammingEstimator) EstimateKeyLength(buf []byte, minKeySize, maxKeySize int) ([]KeyLengthScore, error) {
+	if err := checkKeySizeRange(minKeySize, maxKeySize); err != nil {
+		return nil, err
+	}
+	var scores []KeyLengthScore
+	for keySize := minKeySize; keySize <= maxKeySize; keySize++ {
+		chunks, _ := BytesToChunks(buf, keySize)
+		if len(chunks) < 2 {
+			continue
+		}
+		distance := meanPairwiseHammingDistance(chunks) / float64(keySize)
+		scores = append(scores, KeyLengthScore{keySize, -distance})
+	}
+	if len(scores) == 0 {
+		return nil, fmt.Errorf("Buffer len %d too short for key sizes %d..%d", len(buf), minKeySize, maxKeySize)
+	}
+	sortKeyLengthScores(scores)
+	return scores, nil
+}
+
+// IndexOfCoincidenceEstimator prefers key sizes where each key column has
+// the skewed byte distribution of a single-byte XOR of the plaintext,
+// rather than the flatter mix from several key bytes. Score is the
+// index of coincidence relative to uniformly random bytes.
+type IndexOfCoincidenceEstimator struct{}
+
+func (e IndexOfCoincidenceEstimator) EstimateKeyLength(buf []byte, minKeySize, maxKeySize int) ([]KeyLengthScore, error) {
+	if err := checkKeySizeRange(minKeySize, maxKeySize); err != nil {
+		return nil, err
+	}
+	var scores []KeyLengthScore
+	for keySize := minKeySize; keySize <= maxKeySize; keySize++ {
+		if len(buf) < 2*keySize {
+			continue
+		}
+		coincidences := 0
+		pairs := 0
+		for _, column := range XorKeyColumns(buf, keySize) {
+			var counts [256]int
+			for _, b := range column {
+				counts[b]++
+			}
+			for _, c := range counts {
+				coincidences += c * (c - 1)
+			}
+			pairs += len(column) * (len(column) - 1)
+		}
+		ioc := float64(coincidences) / float64(pairs)
+		scores = append(scores, KeyLengthScore{keySize, ioc * 256})
+	}
+	if len(scores) == 0 {
+		return nil, fmt.Errorf("Buffer len %d too short for key sizes %d..%d", len(buf), minKeySize, maxKeySize)
+	}
+	sortKeyLengthScores(scores)
+	return scores, nil
+}
+
+// KasiskiEstimator looks at the spacing between repeated substrings. A
+// repeat which is also a plaintext repeat is spaced by a multiple of the
+// key size. Score is the fraction of spacings divisible by the key size,
+// less the fraction we'd expect by chance.
+type KasiskiEstimator struct {
+	// Length of repeated substrings to look for. Defaults to 3
+	MinRepeat int
+}
+
+func (e KasiskiEstimator) EstimateKeyLength(buf []byte, minKeySize, maxKeySize int) ([]KeyLengthScore, error) {
+	if err := checkKeySizeRange(minKeySize, maxKeySize); err != nil {
+		return nil, err
+	}
+	minRepeat := e.MinRepeat
+	if minRepeat < 0 {
+		return nil, fmt.Errorf("Bad MinRepeat %d", minRepeat)
+	}
+	if minRepeat == 0 {
+		minRepeat = 3
+	}
+
+	lastSeen := make(map[string]int)
+	var spacings []int
+	for i := 0; i+minRepeat <= len(buf); i++ {
+		s := string(buf[i : i+minRepeat])
+		if j, ok := lastSeen[s]; ok {
+			spacings = append(spacings, i-j)
+		}
+		lastSeen[s] = i
+	}
+	if len(spacings) == 0 {
+		return nil, errors.New("No repeated substrings")
+	}
+
+	var scores []KeyLengthScore
+	for keySize := minKeySize; keySize <= maxKeySize; keySize++ {
+		divisible := 0
+		for _, s := range spacings {
+			if s%keySize == 0 {
+				divisible++
+			}
+		}
+		score := float64(divisible)/float64(len(spacings)) - 1/float64(keySize)
+		scores = append(scores, KeyLengthScore{keySize, score})
+	}
+	sortKeyLengthScores(scores)
+	return scores, nil
+}
+
+// AutocorrelationEstimator compares the ciphertext with itself shifted by
+// each key size. When the shift is a multiple of the key size, matching
+// bytes are matching plaintext bytes, which are far more common than
+// chance. Score is the match rate relative to uniformly random bytes.
+type AutocorrelationEstimator struct{}
+
+func (e AutocorrelationEstimator) EstimateKeyLength(buf []byte, minKeySize, maxKeySize int) ([]KeyLengthScore, error) {
+	if err := checkKeySizeRange(minKeySize, maxKeySize); err != nil {
+		return nil, err
+	}
+	var scores []KeyLengthScore
+	for keySize := minKeySize; keySize <= maxKeySize; keySize++ {
+		n := len(buf) - keySize
+		if n < 1 {
+			continue
+		}
+		matches := 0
+		for i := 0; i < n; i++ {
+			if buf[i] == buf[i+keySize] {
+				matches++
+			}
+		}
+		scores = append(scores, KeyLengthScore{keySize, float64(matches) / float64(n) * 256})
+	}
+	if len(scores) == 0 {
+		return nil, fmt.Errorf("Buffer len %d too short for key sizes %d..%d", len(buf), minKeySize, maxKeySize)
+	}
+	sortKeyLengthScores(scores)
+	return scores, nil
+}
+
+// CombinedEstimator merges the rankings of several estimators with a
+// Borda count - each estimator gives a key size more points the higher
+// it ranks it. Estimators which fail on the input are skipped.
+type CombinedEstimator struct {
+	// Defaults to all the estimators above
+	Estimators []KeyLengthEstimator
+}
+
+func (e CombinedEstimator) EstimateKeyLength(buf []byte, minKeySize, maxKeySize int) ([]KeyLengthScore, error) {
+	if err := checkKeySizeRange(minKeySize, maxKeySize); err != nil {
+		return nil, err
+	}
+	estimators := e.Estimators
+	if len(estimators) == 0 {
+		estimators = []KeyLengthEstimator{
+			HammingEstimator{},
+			IndexOfCoincidenceEstimator{},
+			KasiskiEstimator{},
+			AutocorrelationEstimator{},
+		}
+	}
+
+	points := make(map[int]float64)
+	voters := 0
+	var lastErr error
+	for _, est := range estimators {
+		scores, err := est.EstimateKeyLength(buf, minKeySize, maxKeySize)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		voters++
+		for rank, s := range scores {
+			points[s.KeySize] += float64(len(scores) - rank)
+		}
+	}
+	if voters == 0 {
+		return nil, fmt.Errorf("No estimator could rank key sizes: %w", lastErr)
+	}
+
+	var scores []KeyLengthScore
+	for keySize := minKeySize; keySize <= maxKeySize; keySize++ {
+		p, ok := points[keySize]
+		if !ok {
+			continue
+		}
+		scores = append(scores, KeyLengthScore{keySize, p / float64(voters)})
+	}
+	sortKeyLengthScores(scores)
+	return scores, nil
+}
@@ -0,0 +1,69 @@
+package cpals
+
+import (
+	"testing"
+)
+
+func TestKeyLengthEstimators(t *testing.T) {
+	key := []byte("Vanilla")
+	ctxt := XorKey(Hamlet, key)
+
+	estimators := map[string]KeyLengthEstimator{
+		"hamming":         HammingEstimator{},
+		"ioc":             IndexOfCoincidenceEstimator{},
+		"kasiski":         KasiskiEstimator{},
+		"autocorrelation": AutocorrelationEstimator{},
+		"combined":        CombinedEstimator{},
+	}
+	for name, e := range estimators {
+		t.Run(name, func(t *testing.T) {
+			sizes, err := RankKeyLengths(e, ctxt, 2, 40)
+			if err != nil {
+				t.Fatalf("Can't estimate: %s", err)
+			}
+			if sizes[0]%len(key) != 0 {
+				t.Fatalf("Best size %d not a multiple of %d: %v", sizes[0], len(key), sizes[:5])
+			}
+			t.Logf("Top sizes: %v", sizes[:5])
+
+			_, err = e.EstimateKeyLength(ctxt, 5, 4)
+			if err == nil {
+				t.Fatalf("Didn't error on bad range")
+			}
+		})
+	}
+}
+
+func TestKeyLengthBinaryPlainText(t *testing.T) {
+	// Mostly-zero binary data with some structure, like an executable
+	plainText := make([]byte, 2048)
+	for i := 0; i < len(plainText); i += 64 {
+		copy(plainText[i:], []byte{0x7f, 'E', 'L', 'F', 0x02, 0x01, 0x01})
+	}
+	key := []byte{0xde, 0xad, 0xbe, 0xef, 0x42}
+	ctxt := XorKey(plainText, key)
+
+	for _, e := range []KeyLengthEstimator{IndexOfCoincidenceEstimator{}, AutocorrelationEstimator{}, CombinedEstimator{}} {
+		sizes, err := RankKeyLengths(e, ctxt, 2, 40)
+		if err != nil {
+			t.Fatalf("%T: can't estimate: %s", e, err)
+		}
+		if sizes[0]%len(key) != 0 {
+			t.Fatalf("%T: best size %d not a multiple of %d: %v", e, sizes[0], len(key), sizes[:5])
+		}
+	}
+}
+
+func TestKasiskiErrors(t *testing.T) {
+	_, err := KasiskiEstimator{}.EstimateKeyLength([]byte("abcdefgh"), 2, 4)
+	if err == nil {
+		t.Fatalf("Didn't error with no repeats")
+	}
+	t.Logf("No repeats errored ok: %s", err)
+
+	_, err = KasiskiEstimator{MinRepeat: -1}.EstimateKeyLength(XorKey(Hamlet, []byte("key")), 2, 4)
+	if err == nil {
+		t.Fatalf("Didn't error on negative MinRepeat")
+	}
+	t.Logf("Negative MinRepeat errored ok: %s", err)
+}
@@ -0,0 +1,254 @@
+package cpals
+
+import (
+	"crypto/cipher"
+	"fmt"
+)
+
+// copyIV checks the IV fits the block cipher and copies it, since modes
+// overwrite their chaining state
+func copyIV(b cipher.Block, iv []byte) ([]byte, error) {
+	if len(iv) != b.BlockSize() {
+		return nil, fmt.Errorf("iv length must match blocksize %d != %d", len(iv), b.BlockSize())
+	}
+	ivCopy := make([]byte, len(iv))
+	copy(ivCopy, iv)
+	return ivCopy, nil
+}
+
+// xorInto sets dst to a ^ b over their common length, which it returns
+func xorInto(dst, a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		dst[i] = a[i] ^ b[i]
+	}
+	return n
+}
+
+// cfb is full-block cipher feedback. The keystream for each block is the
+// encryption of the previous ciphertext block.
+type cfb struct {
+	b       cipher.Block
+	next    []byte
+	out     []byte
+	outUsed int
+	decrypt bool
+}
+
+func newCFB(b cipher.Block, iv []byte, decrypt bool) (cipher.Stream, error) {
+	next, err := copyIV(b, iv)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, b.BlockSize())
+	return &cfb{b: b, next: next, out: out, outUsed: len(out), decrypt: decrypt}, nil
+}
+
+func NewCFBEncrypter(b cipher.Block, iv []byte) (cipher.Stream, error) {
+	return newCFB(b, iv, false)
+}
+
+func NewCFBDecrypter(b cipher.Block, iv []byte) (cipher.Stream, error) {
+	return newCFB(b, iv, true)
+}
+
+func (x *cfb) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("crypto/cipher: output smaller than input")
+	}
+	for len(src) > 0 {
+		if x.outUsed == len(x.out) {
+			x.b.Encrypt(x.out, x.next)
+			x.outUsed = 0
+		}
+		if x.decrypt {
+			// Save the ciphertext before dst (which may be src) is written
+			copy(x.next[x.outUsed:], src)
+		}
+		n := xorInto(dst, src, x.out[x.outUsed:])
+		if !x.decrypt {
+			copy(x.next[x.outUsed:], dst[:n])
+		}
+		dst = dst[n:]
+		src = src[n:]
+		x.outUsed += n
+	}
+}
+
+// cfb8 is 8-bit cipher feedback. Each byte is XORed with the first byte of
+// the encrypted shift register, then shifted in to it as ciphertext.
+type cfb8 struct {
+	b        cipher.Block
+	register []byte
+	out      []byte
+	decrypt  bool
+}
+
+func newCFB8(b cipher.Block, iv []byte, decrypt bool) (cipher.Stream, error) {
+	register, err := copyIV(b, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &cfb8{b: b, register: register, out: make([]byte, b.BlockSize()), decrypt: decrypt}, nil
+}
+
+func NewCFB8Encrypter(b cipher.Block, iv []byte) (cipher.Stream, error) {
+	return newCFB8(b, iv, false)
+}
+
+func NewCFB8Decrypter(b cipher.Block, iv []byte) (cipher.Stream, error) {
+	return newCFB8(b, iv, true)
+}
+
+func (x *cfb8) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("crypto/cipher: output smaller than input")
+	}
+	for i := range src {
+		x.b.Encrypt(x.out, x.register)
+		in := src[i]
+		dst[i] = in ^ x.out[0]
+		ctxtByte := dst[i]
+		if x.decrypt {
+			ctxtByte = in
+		}
+		copy(x.register, x.register[1:])
+		x.register[len(x.register)-1] = ctxtByte
+	}
+}
+
+// ofb is output feedback. The keystream is the IV encrypted again and
+// again, independent of the data.
+type ofb struct {
+	b       cipher.Block
+	out     []byte
+	outUsed int
+}
+
+func NewOFB(b cipher.Block, iv []byte) (cipher.Stream, error) {
+	out, err := copyIV(b, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &ofb{b: b, out: out, outUsed: len(out)}, nil
+}
+
+func (x *ofb) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("crypto/cipher: output smaller than input")
+	}
+	for len(src) > 0 {
+		if x.outUsed == len(x.out) {
+			x.b.Encrypt(x.out, x.out)
+			x.outUsed = 0
+		}
+		n := xorInto(dst, src, x.out[x.outUsed:])
+		dst = dst[n:]
+		src = src[n:]
+		x.outUsed += n
+	}
+}
+
+// PCBC (propagating CBC) chains on the XOR of the previous plaintext and
+// ciphertext blocks, so an error spreads to every later block.
+type pcbcEncrypter cbc
+
+func NewPCBCEncrypter(b cipher.Block, iv []byte) (cipher.BlockMode, error) {
+	c, err := newCBC(b, iv)
+	if err != nil {
+		return nil, err
+	}
+	return (*pcbcEncrypter)(c), nil
+}
+
+func (e *pcbcEncrypter) BlockSize() int {
+	return e.b.BlockSize()
+}
+
+func (e *pcbcEncrypter) CryptBlocks(dst, src []byte) {
+	bs := e.BlockSize()
+	checkBlocks(bs, dst, src)
+	ptxtBlock := make([]byte, bs)
+	for i := 0; i < len(src); i += bs {
+		copy(ptxtBlock, src[i:i+bs])
+		block := dst[i : i+bs]
+		xorInto(block, ptxtBlock, e.prev)
+		e.b.Encrypt(block, block)
+		xorInto(e.prev, ptxtBlock, block)
+	}
+}
+
+type pcbcDecrypter cbc
+
+func NewPCBCDecrypter(b cipher.Block, iv []byte) (cipher.BlockMode, error) {
+	c, err := newCBC(b, iv)
+	if err != nil {
+		return nil, err
+	}
+	return (*pcbcDecrypter)(c), nil
+}
+
+func (d *pcbcDecrypter) BlockSize() int {
+	return d.b.BlockSize()
+}
+
+func (d *pcbcDecrypter) CryptBlocks(dst, src []byte) {
+	bs := d.BlockSize()
+	checkBlocks(bs, dst, src)
+	ctxtBlock := make([]byte, bs)
+	for i := 0; i < len(src); i += bs {
+		copy(ctxtBlock, src[i:i+bs])
+		block := dst[i : i+bs]
+		d.b.Decrypt(block, ctxtBlock)
+		xorInto(block, block, d.prev)
+		xorInto(d.prev, block, ctxtBlock)
+	}
+}
+
+// Decrypter decrypts a whole ciphertext with a fresh mode instance
+type Decrypter func(ctxt []byte) []byte
+
+// StreamDecrypter makes a Decrypter from a stream constructor
+func StreamDecrypter(newStream func() cipher.Stream) Decrypter {
+	return func(ctxt []byte) []byte {
+		dst := make([]byte, len(ctxt))
+		newStream().XORKeyStream(dst, ctxt)
+		return dst
+	}
+}
+
+// BlockModeDecrypter makes a Decrypter from a block mode constructor.
+// The ciphertext must be whole blocks.
+func BlockModeDecrypter(newMode func() cipher.BlockMode) Decrypter {
+	return func(ctxt []byte) []byte {
+		dst := make([]byte, len(ctxt))
+		newMode().CryptBlocks(dst, ctxt)
+		return dst
+	}
+}
+
+// BitFlipPropagation flips one bit of the ciphertext and reports which
+// plaintext bytes change as a result, and by how many bits in total
+func BitFlipPropagation(decrypt Decrypter, ctxt []byte, bit int) ([]int, int, error) {
+	if bit < 0 || bit >= 8*len(ctxt) {
+		return nil, 0, fmt.Errorf("Bit %d outside ciphertext of %d bits", bit, 8*len(ctxt))
+	}
+	flipped := make([]byte, len(ctxt))
+	copy(flipped, ctxt)
+	flipped[bit/8] ^= 0x80 >> uint(bit%8)
+
+	good := decrypt(ctxt)
+	bad := decrypt(flipped)
+	var changed []int
+	bitsChanged := 0
+	for i := range good {
+		if good[i] != bad[i] {
+			changed = append(changed, i)
+			bitsChanged += byteHammingDist(good[i], bad[i])
+		}
+	}
+	return changed, bitsChanged, nil
+}
@@ -0,0 +1,207 @@
+package cpals
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+// SP800-38A F.3 and F.4, AES-128
+var (
+	sp800Key = "2b7e151628aed2a6abf7158809cf4f3c"
+	sp800IV  = "000102030405060708090a0b0c0d0e0f"
+	sp800Msg = "6bc1bee22e409f96e93d7e117393172a" +
+		"ae2d8a571e03ac9c9eb76fac45af8e51" +
+		"30c81c46a35ce411e5fbc1191a0a52ef" +
+		"f69f2445df4f9b17ad2b417be66c3710"
+)
+
+func mustDeHex(t *testing.T, h string) []byte {
+	buf, err := DeHex(HexStr(h))
+	if err != nil {
+		t.Fatalf("Bad hex [%s]: %s", h, err)
+	}
+	return buf
+}
+
+type streamMode struct {
+	name     string
+	enc, dec func(cipher.Block, []byte) (cipher.Stream, error)
+	msgLen   int
+	expected string
+}
+
+var streamModes = []streamMode{
+	{"CFB", NewCFBEncrypter, NewCFBDecrypter, 64,
+		"3b3fd92eb72dad20333449f8e83cfb4a" +
+			"c8a64537a0b3a93fcde3cdad9f1ce58b" +
+			"26751f67a3cbb140b1808cf187a4f4df" +
+			"c04b05357c5d1c0eeac4c66f9ff7f2e6"},
+	{"CFB8", NewCFB8Encrypter, NewCFB8Decrypter, 18,
+		"3b79424c9c0dd436bace9e0ed4586a4f32b9"},
+	{"OFB", NewOFB, NewOFB, 64,
+		"3b3fd92eb72dad20333449f8e83cfb4a" +
+			"7789508d16918f03f53c52dac54ed825" +
+			"9740051e9c5fecf64344f7a82260edcc" +
+			"304c6528f659c77866a510d9c1d6ae5e"},
+}
+
+func TestStreamModesKnownAnswer(t *testing.T) {
+	block, _ := aes.NewCipher(mustDeHex(t, sp800Key))
+	iv := mustDeHex(t, sp800IV)
+	for _, m := range streamModes {
+		msg := mustDeHex(t, sp800Msg)[:m.msgLen]
+		enc, err := m.enc(block, iv)
+		if err != nil {
+			t.Fatalf("%s: can't create encrypter: %s", m.name, err)
+		}
+		// Odd sized pieces, to check state carries between calls
+		got := make([]byte, len(msg))
+		for i := 0; i < len(msg); i += 7 {
+			end := i + 7
+			if end > len(msg) {
+				end = len(msg)
+			}
+			enc.XORKeyStream(got[i:end], msg[i:end])
+		}
+		if string(EnHex(got)) != m.expected {
+			t.Fatalf("%s: got %s expected %s", m.name, EnHex(got), m.expected)
+		}
+
+		dec, _ := m.dec(block, iv)
+		dec.XORKeyStream(got, got)
+		if !BytesEqual(got, msg) {
+			t.Fatalf("%s: decrypted in place to %s", m.name, EnHex(got))
+		}
+
+		_, err = m.enc(block, iv[1:])
+		if err == nil {
+			t.Fatalf("%s: didn't error on short iv", m.name)
+		}
+	}
+}
+
+func TestStreamModesMatchStdlib(t *testing.T) {
+	block, _ := aes.NewCipher(RandomBytes(AESBlockSize))
+	iv := RandomBytes(AESBlockSize)
+	msg := RandomBytes(100)
+
+	stdlib := map[string]cipher.Stream{
+		"CFB": cipher.NewCFBEncrypter(block, iv),
+		"OFB": cipher.NewOFB(block, iv),
+	}
+	for _, m := range streamModes {
+		std, ok := stdlib[m.name]
+		if !ok {
+			continue
+		}
+		expected := make([]byte, len(msg))
+		std.XORKeyStream(expected, msg)
+
+		enc, _ := m.enc(block, iv)
+		got := make([]byte, len(msg))
+		enc.XORKeyStream(got, msg)
+		if !BytesEqual(got, expected) {
+			t.Fatalf("%s: got %s expected %s", m.name, EnHex(got), EnHex(expected))
+		}
+	}
+}
+
+func TestPCBC(t *testing.T) {
+	block, _ := aes.NewCipher(RandomBytes(AESBlockSize))
+	iv := RandomBytes(AESBlockSize)
+	msg := RandomBytes(4 * AESBlockSize)
+
+	enc, err := NewPCBCEncrypter(block, iv)
+	if err != nil {
+		t.Fatalf("Can't create encrypter: %s", err)
+	}
+	ctxt := make([]byte, len(msg))
+	enc.CryptBlocks(ctxt, msg)
+
+	// The first block is plain CBC
+	cbcCtxt := make([]byte, AESBlockSize)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(cbcCtxt, msg[:AESBlockSize])
+	if !BytesEqual(ctxt[:AESBlockSize], cbcCtxt) {
+		t.Fatalf("First block %s expected %s", EnHex(ctxt[:AESBlockSize]), EnHex(cbcCtxt))
+	}
+
+	decrypt := BlockModeDecrypter(func() cipher.BlockMode {
+		m, _ := NewPCBCDecrypter(block, iv)
+		return m
+	})
+	if !BytesEqual(decrypt(ctxt), msg) {
+		t.Fatalf("Can't round trip")
+	}
+
+	// Swapping two adjacent ciphertext blocks only garbles those two,
+	// since the XOR of the chaining values is unchanged
+	swapped := make([]byte, len(ctxt))
+	copy(swapped, ctxt)
+	copy(swapped[AESBlockSize:], ctxt[2*AESBlockSize:3*AESBlockSize])
+	copy(swapped[2*AESBlockSize:], ctxt[AESBlockSize:2*AESBlockSize])
+	got := decrypt(swapped)
+	if !BytesEqual(got[3*AESBlockSize:], msg[3*AESBlockSize:]) {
+		t.Fatalf("Swapped blocks garbled the last block")
+	}
+}
+
+func TestBitFlipPropagation(t *testing.T) {
+	key := RandomBytes(AESBlockSize)
+	block, _ := aes.NewCipher(key)
+	iv := RandomBytes(AESBlockSize)
+	// Any ciphertext will do
+	ctxt := RandomBytes(4 * AESBlockSize)
+
+	streamDecrypter := func(newStream func(cipher.Block, []byte) (cipher.Stream, error)) Decrypter {
+		return StreamDecrypter(func() cipher.Stream {
+			s, _ := newStream(block, iv)
+			return s
+		})
+	}
+	blockModeDecrypter := func(newMode func(cipher.Block, []byte) (cipher.BlockMode, error)) Decrypter {
+		return BlockModeDecrypter(func() cipher.BlockMode {
+			m, _ := newMode(block, iv)
+			return m
+		})
+	}
+
+	// Flip a bit of the second block (byte 20)
+	bit := 8*20 + 3
+	testCases := []struct {
+		name     string
+		decrypt  Decrypter
+		minBytes int
+		maxBytes int
+	}{
+		// Just the flipped bit
+		{"OFB", streamDecrypter(NewOFB), 1, 1},
+		// The flipped bit, then the next block garbled
+		{"CFB", streamDecrypter(NewCFBDecrypter), 14, 17},
+		// The flipped byte, then the next 16 garbled while it is in the
+		// shift register
+		{"CFB8", streamDecrypter(NewCFB8Decrypter), 15, 17},
+		// That block garbled, then the same bit flipped in the next
+		{"CBC", blockModeDecrypter(NewCBCDecrypter), 14, 17},
+		// Every block from there on garbled
+		{"PCBC", blockModeDecrypter(NewPCBCDecrypter), 44, 48},
+	}
+	for _, tc := range testCases {
+		changed, bits, err := BitFlipPropagation(tc.decrypt, ctxt, bit)
+		if err != nil {
+			t.Fatalf("%s: can't flip: %s", tc.name, err)
+		}
+		if len(changed) < tc.minBytes || len(changed) > tc.maxBytes {
+			t.Fatalf("%s: %d bytes changed, expected %d-%d", tc.name, len(changed), tc.minBytes, tc.maxBytes)
+		}
+		if changed[0] != 16 && changed[0] != 20 {
+			t.Fatalf("%s: first change at %d", tc.name, changed[0])
+		}
+		t.Logf("%s: %d bytes (%d bits) changed from %d", tc.name, len(changed), bits, changed[0])
+	}
+
+	_, _, err := BitFlipPropagation(testCases[0].decrypt, ctxt, 8*len(ctxt))
+	if err == nil {
+		t.Fatalf("Didn't error on bit off the end")
+	}
+}
@@ -1,12 +1,15 @@
 package cpals
 
-import "fmt"
+import (
+	"bytes"
+	"fmt"
+)
 
 type Oracle func([]byte) []byte
 
-func (oracle Oracle) FindBlockSize() int {
-	blockSize, _ := FindBlockSizeAndFullPadBlock(oracle)
-	return blockSize
+func (oracle Oracle) FindBlockSize() (int, error) {
+	blockSize, _, err := FindBlockSizeAndFullPadBlock(oracle)
+	return blockSize, err
 }
 
 func (oracle Oracle) IsECB(blockSize int) bool {
@@ -15,63 +18,222 @@ func (oracle Oracle) IsECB(blockSize int) bool {
 	return HasDuplicateBlocks(buf, blockSize)
 }
 
+// counting wraps the oracle to count its queries in n
+func (oracle Oracle) counting(n *int) Oracle {
+	return func(buf []byte) []byte {
+		*n++
+		return oracle(buf)
+	}
+}
+
 type PaddingOracle func(iv []byte, buf []byte) bool
 
-// AttackBlock calculates what needs to be XORd with the IV to zero
-// the cipher block
+// PaddingQuery is a padding oracle which can fail, such as one across a
+// network. Its attacks stop at the first error and return it.
+type PaddingQuery func(iv, buf []byte) (bool, error)
+
+// query adapts the oracle to a PaddingQuery which never fails
+func (po PaddingOracle) query() PaddingQuery {
+	return func(iv, buf []byte) (bool, error) {
+		return po(iv, buf), nil
+	}
+}
+
+// AESCBCPaddingOracle reports whether a ciphertext decrypts to valid
+// padding under key and padder
+func AESCBCPaddingOracle(key []byte, padder Padder) PaddingOracle {
+	return func(iv, buf []byte) bool {
+		_, err := AESCBCDecryptWith(key, iv, buf, padder)
+		return err == nil
+	}
+}
+
+// AttackBlock decrypts a block, given the IV (or previous ciphertext
+// block) it was encrypted with, against a PKCS#7 padding oracle
 func (po PaddingOracle) AttackBlock(iv []byte, buf []byte) ([]byte, error) {
+	return po.AttackBlockWith(iv, buf, PKCS7Padder{})
+}
+
+// AttackBlockWith decrypts a block against an oracle for padder
+func (po PaddingOracle) AttackBlockWith(iv []byte, buf []byte, padder Padder) ([]byte, error) {
+	return po.query().AttackBlockWith(iv, buf, padder)
+}
+
+// AttackBlock is PaddingOracle.AttackBlock for an oracle which can fail
+func (pq PaddingQuery) AttackBlock(iv []byte, buf []byte) ([]byte, error) {
+	return pq.AttackBlockWith(iv, buf, PKCS7Padder{})
+}
+
+// AttackBlockWith is PaddingOracle.AttackBlockWith for an oracle which can
+// fail
+func (pq PaddingQuery) AttackBlockWith(iv []byte, buf []byte, padder Padder) ([]byte, error) {
+	return attackBlock(pq, iv, buf, padder, allBytes, nil)
+}
+
+// allBytes is every byte value, in order
+var allBytes = func() []byte {
+	bs := make([]byte, 256)
+	for i := range bs {
+		bs[i] = byte(i)
+	}
+	return bs
+}()
+
+// attackBlock is the padding oracle attack on one block. Working back from
+// the end of the block, we set the bytes we know so they decrypt to the
+// tail of a valid padding, then try plaintext values for the next byte, in
+// the order given, until the oracle accepts one. found, if not nil, is
+// called with each byte as it is recovered.
+func attackBlock(query func(iv, buf []byte) (bool, error), iv []byte, buf []byte, padder Padder, order []byte, found func(pos int, b byte)) ([]byte, error) {
+	vt, ok := padder.(ValidTail)
+	if !ok {
+		return nil, fmt.Errorf("Padding %T doesn't leak enough to attack", padder)
+	}
 	blockSize := len(buf)
-	attackBlock := make([]byte, blockSize)
+	if len(iv) != blockSize {
+		return nil, fmt.Errorf("iv length must match blocksize %d != %d", len(iv), blockSize)
+	}
+	plainBlock := make([]byte, blockSize)
 
 POSITION:
 	for i := blockSize - 1; i >= 0; i-- {
+		tail := vt.ValidTail(blockSize-i, blockSize)
 
-		// This is the byte we will find for good padding at this position
-		paddingByte := blockSize - i
-
-		// Set up the trial block with bit patterns to create the padding we want
-		// apart from the current byte
+		// Set up the trial block so the bytes after this one decrypt to
+		// the rest of the tail
 		trialBlock := make([]byte, blockSize)
-		copy(trialBlock, attackBlock)
-		for j := blockSize - 1; j > i; j-- {
-			trialBlock[j] = attackBlock[j] ^ byte(paddingByte)
-		}
-		trialBlock, err := Xor(trialBlock, iv)
-		if err != nil {
-			panic("wtf?")
+		copy(trialBlock, iv)
+		for j := i + 1; j < blockSize; j++ {
+			trialBlock[j] ^= plainBlock[j] ^ tail[j-i]
 		}
 
-		// Try each byte in position
-		for b := 0; b < 256; b++ {
-			trialBlock[i] ^= byte(b)
-
-			//			fmt.Printf("TRY: %s\n", BytesHexBlocks(trialBlock, blockSize))
-			paddingGood := po(trialBlock, buf)
-
-			if paddingGood {
-				// Could be a false positive. If scrambling the next byte is also
-				// OK then it isn't
-				if i > 0 {
-					//					fmt.Printf("Possible %02X for pos %d\n", b, i)
-					trialBlock[i-1] ^= 0x01
-					paddingGood = po(trialBlock, buf)
-					// Remove changes
-					trialBlock[i-1] ^= 0x01
+		// Try each plaintext byte in position
+		for _, p := range order {
+			trialBlock[i] = iv[i] ^ p ^ tail[0]
+			paddingGood, err := query(trialBlock, buf)
+			if err != nil {
+				return nil, err
+			}
+			if !paddingGood {
+				continue
+			}
+			// Could be a false positive, from valid padding longer than
+			// the tail. If scrambling the previous byte is also OK then
+			// it isn't
+			if i > 0 {
+				trialBlock[i-1] ^= 0x01
+				paddingGood, err = query(trialBlock, buf)
+				trialBlock[i-1] ^= 0x01
+				if err != nil {
+					return nil, err
 				}
-
-				if paddingGood {
-					//					fmt.Printf("Found %02X for pos %d\n", b, i)
-					attackBlock[i] = byte(b) ^ byte(paddingByte)
-					//					fmt.Printf("AB : %s\n", BytesHexBlocks(attackBlock, blockSize))
-					trialBlock[i] ^= byte(b)
-					continue POSITION
+				if !paddingGood {
+					continue
 				}
 			}
-			trialBlock[i] ^= byte(b)
+			plainBlock[i] = p
+			if found != nil {
+				found(i, p)
+			}
+			continue POSITION
 		}
 		return nil, fmt.Errorf("Can't find byte for position %d", i)
+	}
+
+	return plainBlock, nil
+}
 
+// counting wraps the oracle to count its queries in n
+func (pq PaddingQuery) counting(n *int) PaddingQuery {
+	return func(iv, buf []byte) (bool, error) {
+		*n++
+		return pq(iv, buf)
 	}
+}
+
+// Decrypt decrypts a whole PKCS#7 padded ciphertext against the oracle,
+// returning the message and the number of oracle queries used
+func (po PaddingOracle) Decrypt(iv, ctxt []byte) ([]byte, int, error) {
+	return po.DecryptWith(iv, ctxt, PKCS7Padder{})
+}
+
+// DecryptWith decrypts each block with the previous ciphertext block as its
+// IV, then unpads with padder
+func (po PaddingOracle) DecryptWith(iv, ctxt []byte, padder Padder) ([]byte, int, error) {
+	return po.query().DecryptWith(iv, ctxt, padder)
+}
 
-	return attackBlock, nil
+// Decrypt is PaddingOracle.Decrypt for an oracle which can fail
+func (pq PaddingQuery) Decrypt(iv, ctxt []byte) ([]byte, int, error) {
+	return pq.DecryptWith(iv, ctxt, PKCS7Padder{})
+}
+
+// DecryptWith is PaddingOracle.DecryptWith for an oracle which can fail
+func (pq PaddingQuery) DecryptWith(iv, ctxt []byte, padder Padder) ([]byte, int, error) {
+	queries := 0
+	counted := pq.counting(&queries)
+	blockSize := len(iv)
+	if blockSize == 0 || len(ctxt) == 0 || len(ctxt)%blockSize != 0 {
+		return nil, 0, fmt.Errorf("Ciphertext len %d not a multiple of iv len %d", len(ctxt), blockSize)
+	}
+	msg := make([]byte, 0, len(ctxt))
+	prev := iv
+	for i := 0; i < len(ctxt); i += blockSize {
+		block := ctxt[i : i+blockSize]
+		plainBlock, err := counted.AttackBlockWith(prev, block, padder)
+		if err != nil {
+			return nil, queries, fmt.Errorf("Can't attack block %d: %w", i/blockSize, err)
+		}
+		msg = append(msg, plainBlock...)
+		prev = block
+	}
+	unpadded, err := padder.Unpad(msg, blockSize)
+	if err != nil {
+		return nil, queries, fmt.Errorf("Can't unpad: %w", err)
+	}
+	return unpadded, queries, nil
+}
+
+// Encrypt makes an IV and ciphertext which decrypt to msg, without the
+// key, by CBC-R. Working back from a random last block, the oracle gives
+// the block's decryption, and the previous block is chosen so that XORed
+// with it gives the plaintext we want. The block size is AES's.
+func (po PaddingOracle) Encrypt(msg []byte) ([]byte, []byte, int, error) {
+	return po.EncryptWith(msg, PKCS7Padder{})
+}
+
+// EncryptWith is Encrypt with another padding
+func (po PaddingOracle) EncryptWith(msg []byte, padder Padder) ([]byte, []byte, int, error) {
+	return po.query().EncryptWith(msg, padder)
+}
+
+// Encrypt is PaddingOracle.Encrypt for an oracle which can fail
+func (pq PaddingQuery) Encrypt(msg []byte) ([]byte, []byte, int, error) {
+	return pq.EncryptWith(msg, PKCS7Padder{})
+}
+
+// EncryptWith is PaddingOracle.EncryptWith for an oracle which can fail
+func (pq PaddingQuery) EncryptWith(msg []byte, padder Padder) ([]byte, []byte, int, error) {
+	queries := 0
+	counted := pq.counting(&queries)
+	blockSize := AESBlockSize
+	padded := padder.Pad(msg, blockSize)
+	zeroIV := make([]byte, blockSize)
+
+	// blocks[0] is the IV
+	numBlocks := len(padded) / blockSize
+	blocks := make([][]byte, numBlocks+1)
+	blocks[numBlocks] = RandomBytes(blockSize)
+	for i := numBlocks; i > 0; i-- {
+		// With a zero IV the "plaintext" is the block's decryption
+		decrypted, err := counted.AttackBlockWith(zeroIV, blocks[i], padder)
+		if err != nil {
+			return nil, nil, queries, fmt.Errorf("Can't attack block %d: %w", i, err)
+		}
+		blocks[i-1], err = Xor(decrypted, padded[(i-1)*blockSize:i*blockSize])
+		if err != nil {
+			return nil, nil, queries, fmt.Errorf("Internal error: %w", err)
+		}
+	}
+	return blocks[0], bytes.Join(blocks[1:], nil), queries, nil
 }
@@ -0,0 +1,300 @@
+package cpals
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+)
+
+// Padder pads messages out to whole blocks and checks and strips that
+// padding again
+type Padder interface {
+	Pad(buf []byte, blockSize int) []byte
+	Unpad(buf []byte, blockSize int) ([]byte, error)
+}
+
+// ValidTail is implemented by paddings which a padding oracle can be used
+// to decrypt. It gives the n byte block tail which is exactly n bytes of
+// valid padding, with no other tail of that length being valid.
+type ValidTail interface {
+	ValidTail(n, blockSize int) []byte
+}
+
+// padBuf copies buf with room for the padding
+func padBuf(buf []byte, blockSize int) ([]byte, int) {
+	padLen := blockSize - len(buf)%blockSize
+	padded := make([]byte, len(buf)+padLen)
+	copy(padded, buf)
+	return padded, padLen
+}
+
+func checkPadded(buf []byte, blockSize int) error {
+	if len(buf) == 0 {
+		return errors.New("Empty buf is not padded")
+	}
+	return checkFullBlocks(blockSize, buf)
+}
+
+// padLength reads and checks a trailing length byte
+func padLength(buf []byte, blockSize int) (int, error) {
+	padVal := int(buf[len(buf)-1])
+	if padVal > blockSize {
+		return 0, fmt.Errorf("Pad byte too large %d > %d", padVal, blockSize)
+	}
+	if padVal == 0 {
+		return 0, fmt.Errorf("Zero pad byte")
+	}
+	return padVal, nil
+}
+
+// PKCS7Padder fills with bytes equal to the padding length
+type PKCS7Padder struct{}
+
+func (p PKCS7Padder) Pad(buf []byte, blockSize int) []byte {
+	padded, padLen := padBuf(buf, blockSize)
+	copy(padded[len(buf):], p.ValidTail(padLen, blockSize))
+	return padded
+}
+
+func (p PKCS7Padder) Unpad(buf []byte, blockSize int) ([]byte, error) {
+	if err := checkPadded(buf, blockSize); err != nil {
+		return nil, err
+	}
+	if _, err := padLength(buf, blockSize); err != nil {
+		return nil, err
+	}
+	return BytesPKCS7UnPad(buf)
+}
+
+func (p PKCS7Padder) ValidTail(n, blockSize int) []byte {
+	return NewBytes(n, byte(n))
+}
+
+// ANSIX923Padder fills with zeros, then the padding length
+type ANSIX923Padder struct{}
+
+func (p ANSIX923Padder) Pad(buf []byte, blockSize int) []byte {
+	padded, padLen := padBuf(buf, blockSize)
+	copy(padded[len(buf):], p.ValidTail(padLen, blockSize))
+	return padded
+}
+
+func (p ANSIX923Padder) Unpad(buf []byte, blockSize int) ([]byte, error) {
+	if err := checkPadded(buf, blockSize); err != nil {
+		return nil, err
+	}
+	padVal, err := padLength(buf, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < padVal; i++ {
+		if buf[len(buf)-1-i] != 0 {
+			return nil, fmt.Errorf("Invalid pad byte %d from end: %d != 0", i, buf[len(buf)-1-i])
+		}
+	}
+	return buf[:len(buf)-padVal], nil
+}
+
+func (p ANSIX923Padder) ValidTail(n, blockSize int) []byte {
+	tail := make([]byte, n)
+	tail[n-1] = byte(n)
+	return tail
+}
+
+// ISO10126Padder fills with random bytes, then the padding length. Only
+// the length can be checked.
+type ISO10126Padder struct{}
+
+func (p ISO10126Padder) Pad(buf []byte, blockSize int) []byte {
+	padded, padLen := padBuf(buf, blockSize)
+	copy(padded[len(buf):], RandomBytes(padLen-1))
+	padded[len(padded)-1] = byte(padLen)
+	return padded
+}
+
+func (p ISO10126Padder) Unpad(buf []byte, blockSize int) ([]byte, error) {
+	if err := checkPadded(buf, blockSize); err != nil {
+		return nil, err
+	}
+	padVal, err := padLength(buf, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:len(buf)-padVal], nil
+}
+
+// ISO7816Padder appends a 0x80 byte, then fills with zeros
+type ISO7816Padder struct{}
+
+func (p ISO7816Padder) Pad(buf []byte, blockSize int) []byte {
+	padded, padLen := padBuf(buf, blockSize)
+	copy(padded[len(buf):], p.ValidTail(padLen, blockSize))
+	return padded
+}
+
+func (p ISO7816Padder) Unpad(buf []byte, blockSize int) ([]byte, error) {
+	if err := checkPadded(buf, blockSize); err != nil {
+		return nil, err
+	}
+	for i := len(buf) - 1; i >= len(buf)-blockSize; i-- {
+		switch buf[i] {
+		case 0:
+			continue
+		case 0x80:
+			return buf[:i], nil
+		default:
+			return nil, fmt.Errorf("Invalid pad byte %d from end: %d != 0x80", len(buf)-1-i, buf[i])
+		}
+	}
+	return nil, errors.New("No 0x80 in last block")
+}
+
+func (p ISO7816Padder) ValidTail(n, blockSize int) []byte {
+	tail := make([]byte, n)
+	tail[0] = 0x80
+	return tail
+}
+
+// ZeroPadder fills with zeros, adding nothing to messages which are
+// already whole blocks. Unpadding strips all trailing zeros, so it can't
+// carry messages which end in a zero, and never fails.
+type ZeroPadder struct{}
+
+func (p ZeroPadder) Pad(buf []byte, blockSize int) []byte {
+	if len(buf)%blockSize == 0 {
+		padded := make([]byte, len(buf))
+		copy(padded, buf)
+		return padded
+	}
+	padded, _ := padBuf(buf, blockSize)
+	return padded
+}
+
+func (p ZeroPadder) Unpad(buf []byte, blockSize int) ([]byte, error) {
+	if err := checkFullBlocks(blockSize, buf); err != nil {
+		return nil, err
+	}
+	i := len(buf)
+	for i > 0 && buf[i-1] == 0 {
+		i--
+	}
+	return buf[:i], nil
+}
+
+// CTSVariant says how CBC ciphertext stealing orders the last two
+// blocks, as in the NIST SP800-38A addendum
+type CTSVariant int
+
+const (
+	// CS1 keeps the CBC order: the partial block, then the full one
+	CS1 CTSVariant = iota + 1
+	// CS2 swaps the last two blocks if the last is partial
+	CS2
+	// CS3 always swaps the last two blocks (Kerberos, RFC 3962)
+	CS3
+)
+
+// swapsLastBlocks reports whether the variant puts the full block before
+// the partial one
+func (v CTSVariant) swapsLastBlocks(partial int) bool {
+	return v == CS3 || (v == CS2 && partial != 0)
+}
+
+func checkCTS(b cipher.Block, buf []byte, variant CTSVariant) error {
+	if variant < CS1 || variant > CS3 {
+		return fmt.Errorf("Unknown CTS variant %d", variant)
+	}
+	if len(buf) < b.BlockSize() {
+		return fmt.Errorf("Buffer len %d shorter than a block", len(buf))
+	}
+	return nil
+}
+
+// CBCCTSEncrypt is CBC with ciphertext stealing. The ciphertext is the
+// same length as the message, which must be at least one block. A single
+// block message is plain CBC in every variant.
+func CBCCTSEncrypt(b cipher.Block, iv, msg []byte, variant CTSVariant) ([]byte, error) {
+	if err := checkCTS(b, msg, variant); err != nil {
+		return nil, err
+	}
+	bs := b.BlockSize()
+	partial := len(msg) % bs
+	padded := ZeroPadder{}.Pad(msg, bs)
+	enc, err := NewCBCEncrypter(b, iv)
+	if err != nil {
+		return nil, err
+	}
+	enc.CryptBlocks(padded, padded)
+	if len(padded) == bs {
+		return padded, nil
+	}
+
+	last := padded[len(padded)-bs:]
+	secondLast := padded[len(padded)-2*bs : len(padded)-bs]
+	if partial != 0 {
+		// Drop the end of the second last block. Decryption can recover
+		// it from the last block, since the message was zero padded.
+		secondLast = secondLast[:partial]
+	}
+	ctxt := make([]byte, 0, len(msg))
+	ctxt = append(ctxt, padded[:len(padded)-2*bs]...)
+	if variant.swapsLastBlocks(partial) {
+		ctxt = append(ctxt, last...)
+		ctxt = append(ctxt, secondLast...)
+	} else {
+		ctxt = append(ctxt, secondLast...)
+		ctxt = append(ctxt, last...)
+	}
+	return ctxt, nil
+}
+
+func CBCCTSDecrypt(b cipher.Block, iv, ctxt []byte, variant CTSVariant) ([]byte, error) {
+	if err := checkCTS(b, ctxt, variant); err != nil {
+		return nil, err
+	}
+	bs := b.BlockSize()
+	partial := len(ctxt) % bs
+	dec, err := NewCBCDecrypter(b, iv)
+	if err != nil {
+		return nil, err
+	}
+	if len(ctxt) == bs {
+		msg := make([]byte, bs)
+		dec.CryptBlocks(msg, ctxt)
+		return msg, nil
+	}
+
+	// Put the last two blocks back in CS1 order
+	tailLen := bs + partial
+	if partial == 0 {
+		tailLen = 2 * bs
+	}
+	head := ctxt[:len(ctxt)-tailLen]
+	tail := ctxt[len(ctxt)-tailLen:]
+	secondLen := tailLen - bs
+	var secondLast, last []byte
+	if variant.swapsLastBlocks(partial) {
+		last, secondLast = tail[:bs], tail[bs:]
+	} else {
+		secondLast, last = tail[:secondLen], tail[secondLen:]
+	}
+
+	// The decrypted last block is the last plaintext block XOR the second
+	// last ciphertext block. The plaintext was zero padded, so its end is
+	// the stolen end of the second last ciphertext block.
+	z := make([]byte, bs)
+	b.Decrypt(z, last)
+	fullSecondLast := make([]byte, bs)
+	copy(fullSecondLast, secondLast)
+	copy(fullSecondLast[secondLen:], z[secondLen:])
+
+	cbcCtxt := make([]byte, 0, len(head)+bs)
+	cbcCtxt = append(cbcCtxt, head...)
+	cbcCtxt = append(cbcCtxt, fullSecondLast...)
+	msg := make([]byte, len(cbcCtxt), len(ctxt))
+	dec.CryptBlocks(msg, cbcCtxt)
+
+	lastMsg := make([]byte, bs)
+	xorInto(lastMsg, z, fullSecondLast)
+	return append(msg, lastMsg[:secondLen]...), nil
+}
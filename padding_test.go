@@ -0,0 +1,219 @@
+package cpals
+
+import (
+	"crypto/aes"
+	"testing"
+)
+
+var padders = []struct {
+	name   string
+	padder Padder
+	bad    []string
+}{
+	{"PKCS7", PKCS7Padder{}, []string{
+		"41414141414141414141414141414100",
+		"41414141414141414141414141410302",
+		"41414141414141414141414141414111",
+	}},
+	{"ANSIX923", ANSIX923Padder{}, []string{
+		"41414141414141414141414141414100",
+		"41414141414141414141414141410103",
+		"41414141414141414141414141414111",
+	}},
+	{"ISO10126", ISO10126Padder{}, []string{
+		"41414141414141414141414141414100",
+		"41414141414141414141414141414111",
+	}},
+	{"ISO7816", ISO7816Padder{}, []string{
+		"41414141414141414141414141414100",
+		"41414141414141414141414141800001",
+		"00000000000000000000000000000000",
+	}},
+	{"Zero", ZeroPadder{}, nil},
+}
+
+func TestPadders(t *testing.T) {
+	for _, p := range padders {
+		for n := 0; n <= 2*AESBlockSize; n++ {
+			msg := NewBytes(n, 'A')
+			padded := p.padder.Pad(msg, AESBlockSize)
+			if len(padded)%AESBlockSize != 0 || len(padded) < n {
+				t.Fatalf("%s: padded %d bytes to %d", p.name, n, len(padded))
+			}
+			got, err := p.padder.Unpad(padded, AESBlockSize)
+			if err != nil {
+				t.Fatalf("%s: can't unpad %d bytes: %s", p.name, n, err)
+			}
+			if !BytesEqual(got, msg) {
+				t.Fatalf("%s: round tripped %d bytes to %d", p.name, n, len(got))
+			}
+		}
+
+		for _, bad := range p.bad {
+			_, err := p.padder.Unpad(mustDeHex(t, bad), AESBlockSize)
+			if err == nil {
+				t.Fatalf("%s: didn't error on %s", p.name, bad)
+			}
+			t.Logf("%s: errored ok: %s", p.name, err)
+		}
+		_, err := p.padder.Unpad(NewBytes(AESBlockSize+1, 1), AESBlockSize)
+		if err == nil {
+			t.Fatalf("%s: didn't error on partial block", p.name)
+		}
+	}
+}
+
+func TestAESCBCWith(t *testing.T) {
+	key := RandomBytes(AESBlockSize)
+	iv := RandomBytes(AESBlockSize)
+	msg := []byte("Not a whole number of blocks")
+	for _, p := range padders {
+		ctxt, err := AESCBCEncryptWith(key, iv, msg, p.padder)
+		if err != nil {
+			t.Fatalf("%s: can't encrypt: %s", p.name, err)
+		}
+		got, err := AESCBCDecryptWith(key, iv, ctxt, p.padder)
+		if err != nil || !BytesEqual(got, msg) {
+			t.Fatalf("%s: can't round trip: %s", p.name, err)
+		}
+	}
+
+	_, err := AESCBCEncryptWith(key, iv, msg, nil)
+	if err == nil {
+		t.Fatalf("Didn't error on unpadded partial block")
+	}
+}
+
+// RFC 3962 appendix B, which is CS3
+func TestCBCCTSKnownAnswer(t *testing.T) {
+	block, _ := aes.NewCipher([]byte("chicken teriyaki"))
+	iv := make([]byte, AESBlockSize)
+	msg := []byte("I would like the General Gau's Chicken, please, and wonton soup.")
+	testCases := []struct {
+		msgLen   int
+		expected string
+	}{
+		{17, "c6353568f2bf8cb4d8a580362da7ff7f97"},
+		{31, "fc00783e0efdb2c1d445d4c8eff7ed2297687268d6ecccc0c07b25e25ecfe5"},
+		{32, "39312523a78662d5be7fcbcc98ebf5a897687268d6ecccc0c07b25e25ecfe584"},
+		{47, "97687268d6ecccc0c07b25e25ecfe584b3fffd940c16a18c1b5549d2f838029e39312523a78662d5be7fcbcc98ebf5"},
+		{48, "97687268d6ecccc0c07b25e25ecfe5849dad8bbb96c4cdc03bc103e1a194bbd839312523a78662d5be7fcbcc98ebf5a8"},
+		{64, "97687268d6ecccc0c07b25e25ecfe58439312523a78662d5be7fcbcc98ebf5a84807efe836ee89a526730dbc2f7bc8409dad8bbb96c4cdc03bc103e1a194bbd8"},
+	}
+	for _, tc := range testCases {
+		got, err := CBCCTSEncrypt(block, iv, msg[:tc.msgLen], CS3)
+		if err != nil {
+			t.Fatalf("%d: can't encrypt: %s", tc.msgLen, err)
+		}
+		if string(EnHex(got)) != tc.expected {
+			t.Fatalf("%d: got %s expected %s", tc.msgLen, EnHex(got), tc.expected)
+		}
+		back, err := CBCCTSDecrypt(block, iv, got, CS3)
+		if err != nil || !BytesEqual(back, msg[:tc.msgLen]) {
+			t.Fatalf("%d: can't round trip: %s", tc.msgLen, err)
+		}
+	}
+}
+
+func TestCBCCTSVariants(t *testing.T) {
+	block, _ := aes.NewCipher(RandomBytes(AESBlockSize))
+	iv := RandomBytes(AESBlockSize)
+	for _, variant := range []CTSVariant{CS1, CS2, CS3} {
+		for n := AESBlockSize; n <= 4*AESBlockSize; n++ {
+			msg := RandomBytes(n)
+			ctxt, err := CBCCTSEncrypt(block, iv, msg, variant)
+			if err != nil {
+				t.Fatalf("CS%d: can't encrypt %d bytes: %s", variant, n, err)
+			}
+			if len(ctxt) != n {
+				t.Fatalf("CS%d: encrypted %d bytes to %d", variant, n, len(ctxt))
+			}
+			got, err := CBCCTSDecrypt(block, iv, ctxt, variant)
+			if err != nil || !BytesEqual(got, msg) {
+				t.Fatalf("CS%d: can't round trip %d bytes: %s", variant, n, err)
+			}
+		}
+	}
+
+	// CS1 and CS2 only differ when the last block is partial
+	msg := RandomBytes(3 * AESBlockSize)
+	cs1, _ := CBCCTSEncrypt(block, iv, msg, CS1)
+	cs2, _ := CBCCTSEncrypt(block, iv, msg, CS2)
+	if !BytesEqual(cs1, cs2) {
+		t.Fatalf("CS1 and CS2 differ on whole blocks")
+	}
+
+	_, err := CBCCTSEncrypt(block, iv, msg[:AESBlockSize-1], CS3)
+	if err == nil {
+		t.Fatalf("Didn't error on short message")
+	}
+	t.Logf("errored ok: %s", err)
+}
+
+func TestPaddingOracleWith(t *testing.T) {
+	key := RandomBytes(AESBlockSize)
+	iv := RandomBytes(AESBlockSize)
+	msg := []byte("YELLOW SUBMARINE")
+	for _, p := range padders {
+		ctxt, err := AESCBCEncryptWith(key, iv, msg, p.padder)
+		if err != nil {
+			t.Fatalf("%s: can't encrypt: %s", p.name, err)
+		}
+		po := AESCBCPaddingOracle(key, p.padder)
+		got, err := po.AttackBlockWith(iv, ctxt[:AESBlockSize], p.padder)
+		if _, ok := p.padder.(ValidTail); !ok {
+			if err == nil {
+				t.Fatalf("%s: didn't error", p.name)
+			}
+			t.Logf("%s: errored ok: %s", p.name, err)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: can't attack: %s", p.name, err)
+		}
+		if !BytesEqual(got, msg) {
+			t.Fatalf("%s: got %s", p.name, got)
+		}
+	}
+}
+
+func TestPaddingOracleDecryptEncrypt(t *testing.T) {
+	key := RandomKey()
+	iv := RandomBytes(AESBlockSize)
+	po := AESCBCPaddingOracle(key, PKCS7Padder{})
+
+	msg := []byte("Decrypt me without the key, if you can")
+	got, queries, err := po.Decrypt(iv, AESCBCEncrypt(key, iv, msg))
+	if err != nil {
+		t.Fatalf("Can't decrypt: %s", err)
+	}
+	if !BytesEqual(got, msg) {
+		t.Fatalf("Got %s", got)
+	}
+	// About 128 guesses per byte on average, plus false positive checks
+	numBytes := len(msg) + AESBlockSize - len(msg)%AESBlockSize
+	if queries < numBytes || queries > 256*numBytes+numBytes {
+		t.Fatalf("Used %d queries for %d bytes", queries, numBytes)
+	}
+	t.Logf("Decrypted %d bytes in %d queries", numBytes, queries)
+
+	wanted := []byte("user=mallory;role=admin;and a bit more")
+	forgedIV, ctxt, queries, err := po.Encrypt(wanted)
+	if err != nil {
+		t.Fatalf("Can't encrypt: %s", err)
+	}
+	got, err = AESCBCDecryptErr(key, forgedIV, ctxt)
+	if err != nil {
+		t.Fatalf("Forgery doesn't decrypt: %s", err)
+	}
+	if !BytesEqual(got, wanted) {
+		t.Fatalf("Forgery decrypted to %s", got)
+	}
+	t.Logf("Encrypted %d bytes in %d queries", len(ctxt), queries)
+
+	_, _, err = po.Decrypt(iv, RandomBytes(AESBlockSize+1))
+	if err == nil {
+		t.Fatalf("Didn't error on partial block")
+	}
+	t.Logf("errored ok: %s", err)
+}
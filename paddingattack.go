@@ -0,0 +1,179 @@
+package cpals
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrQueryBudget is returned when a padding oracle attack runs out of
+// queries
+var ErrQueryBudget = errors.New("Padding oracle query budget exhausted")
+
+// LikelyBytes puts printable ASCII first, roughly by English frequency,
+// then the padding bytes, then everything else
+var LikelyBytes = completeOrder([]byte(" etaoinshrdlucmfwypvbgkjqxzETAOINSHRDLUCMFWYPVBGKJQXZ.,'\"-?!0123456789\n:;()" +
+	"#$%&*+/<=>@[\\]^_`{|}~\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10"))
+
+// completeOrder appends any byte values missing from order, so every
+// guess gets tried in the end
+func completeOrder(order []byte) []byte {
+	seen := make(map[byte]bool)
+	var complete []byte
+	for _, bs := range [][]byte{order, allBytes} {
+		for _, b := range bs {
+			if !seen[b] {
+				seen[b] = true
+				complete = append(complete, b)
+			}
+		}
+	}
+	return complete
+}
+
+// PaddingProgress reports a padding oracle attack as it goes
+type PaddingProgress struct {
+	// Block and Pos say which byte was just recovered
+	Block, Pos int
+	// Plaintext is everything so far, still padded, with zeros where
+	// bytes aren't known yet
+	Plaintext []byte
+	Recovered int
+	Queries   int
+}
+
+// PaddingAttackOpts configures DecryptParallel. The zero value is usable.
+type PaddingAttackOpts struct {
+	// Workers is how many blocks are attacked at once. Default 4.
+	Workers int
+	// Budget is the most oracle queries to make, with 0 meaning no limit
+	Budget int
+	// Padder is the padding the oracle checks. Default PKCS7.
+	Padder Padder
+	// Guesses is the order to try plaintext bytes in. Bytes not
+	// mentioned are tried last. Default LikelyBytes.
+	Guesses []byte
+	// Progress, if set, is called as each byte is recovered. Calls are
+	// never concurrent.
+	Progress func(PaddingProgress)
+}
+
+func (opts *PaddingAttackOpts) defaults() {
+	if opts.Workers == 0 {
+		opts.Workers = 4
+	}
+	if opts.Padder == nil {
+		opts.Padder = PKCS7Padder{}
+	}
+	if opts.Guesses == nil {
+		opts.Guesses = LikelyBytes
+	} else {
+		opts.Guesses = completeOrder(opts.Guesses)
+	}
+}
+
+// DecryptParallel decrypts a whole ciphertext like Decrypt, but attacks
+// several blocks at once, since each depends only on the ciphertext before
+// it. It stops when ctx is done or the query budget is spent. It returns
+// the unpadded message and the number of queries made. On error it
+// returns what was recovered so far instead, still padded, with zeros
+// where bytes aren't known.
+func (po PaddingOracle) DecryptParallel(ctx context.Context, iv, ctxt []byte, opts PaddingAttackOpts) ([]byte, int, error) {
+	return po.query().DecryptParallel(ctx, iv, ctxt, opts)
+}
+
+// DecryptParallel is PaddingOracle.DecryptParallel for an oracle which can
+// fail. The first error stops the attack and is returned.
+func (pq PaddingQuery) DecryptParallel(ctx context.Context, iv, ctxt []byte, opts PaddingAttackOpts) ([]byte, int, error) {
+	if opts.Workers < 0 {
+		return nil, 0, fmt.Errorf("Can't have %d workers", opts.Workers)
+	}
+	opts.defaults()
+	blockSize := len(iv)
+	if blockSize == 0 || len(ctxt) == 0 || len(ctxt)%blockSize != 0 {
+		return nil, 0, fmt.Errorf("Ciphertext len %d not a multiple of iv len %d", len(ctxt), blockSize)
+	}
+	numBlocks := len(ctxt) / blockSize
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var queries int64
+	query := func(iv, buf []byte) (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		n := atomic.AddInt64(&queries, 1)
+		if opts.Budget > 0 && n > int64(opts.Budget) {
+			return false, ErrQueryBudget
+		}
+		return pq(iv, buf)
+	}
+
+	var mu sync.Mutex
+	plaintext := make([]byte, len(ctxt))
+	recovered := 0
+	found := func(block int) func(int, byte) {
+		return func(pos int, b byte) {
+			mu.Lock()
+			defer mu.Unlock()
+			plaintext[block*blockSize+pos] = b
+			recovered++
+			if opts.Progress != nil {
+				opts.Progress(PaddingProgress{
+					Block:     block,
+					Pos:       pos,
+					Plaintext: append([]byte{}, plaintext...),
+					Recovered: recovered,
+					Queries:   int(atomic.LoadInt64(&queries)),
+				})
+			}
+		}
+	}
+
+	blocks := make(chan int, numBlocks)
+	for i := 0; i < numBlocks; i++ {
+		blocks <- i
+	}
+	close(blocks)
+
+	var wg sync.WaitGroup
+	var firstErr error
+	var errOnce sync.Once
+	for w := 0; w < opts.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range blocks {
+				prev := iv
+				if i > 0 {
+					prev = ctxt[(i-1)*blockSize : i*blockSize]
+				}
+				block := ctxt[i*blockSize : (i+1)*blockSize]
+				_, err := attackBlock(query, prev, block, opts.Padder, opts.Guesses, found(i))
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("Can't attack block %d: %w", i, err)
+						cancel()
+					})
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	used := int(atomic.LoadInt64(&queries))
+	if opts.Budget > 0 && used > opts.Budget {
+		used = opts.Budget
+	}
+	if firstErr != nil {
+		return plaintext, used, firstErr
+	}
+	msg, err := opts.Padder.Unpad(plaintext, blockSize)
+	if err != nil {
+		return plaintext, used, fmt.Errorf("Can't unpad: %w", err)
+	}
+	return msg, used, nil
+}
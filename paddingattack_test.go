@@ -0,0 +1,116 @@
+package cpals
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDecryptParallel(t *testing.T) {
+	key := RandomKey()
+	iv := RandomBytes(AESBlockSize)
+	msg := []byte("The quick brown fox jumps over the lazy dog, several times over.")
+	ctxt := AESCBCEncrypt(key, iv, msg)
+	po := AESCBCPaddingOracle(key, PKCS7Padder{})
+
+	_, seqQueries, err := po.Decrypt(iv, ctxt)
+	if err != nil {
+		t.Fatalf("Can't decrypt sequentially: %s", err)
+	}
+
+	// Progress runs on the worker goroutines, so can't fail the test
+	updates := 0
+	lastRecovered := 0
+	var progressErr error
+	got, queries, err := po.DecryptParallel(context.Background(), iv, ctxt, PaddingAttackOpts{
+		Progress: func(p PaddingProgress) {
+			updates++
+			if p.Recovered != lastRecovered+1 && progressErr == nil {
+				progressErr = fmt.Errorf("Recovered went from %d to %d", lastRecovered, p.Recovered)
+			}
+			lastRecovered = p.Recovered
+		},
+	})
+	if err != nil {
+		t.Fatalf("Can't decrypt: %s", err)
+	}
+	if progressErr != nil {
+		t.Fatalf("Bad progress: %s", progressErr)
+	}
+	if !BytesEqual(got, msg) {
+		t.Fatalf("Got %s", got)
+	}
+	if updates != len(ctxt) {
+		t.Fatalf("Got %d updates for %d bytes", updates, len(ctxt))
+	}
+	// English goes much faster with likely bytes first
+	if queries*2 > seqQueries {
+		t.Fatalf("Used %d queries, sequential used %d", queries, seqQueries)
+	}
+	t.Logf("Used %d queries, sequential used %d", queries, seqQueries)
+}
+
+func TestDecryptParallelLimits(t *testing.T) {
+	key := RandomKey()
+	iv := RandomBytes(AESBlockSize)
+	msg := RandomBytes(100)
+	ctxt := AESCBCEncrypt(key, iv, msg)
+	po := AESCBCPaddingOracle(key, PKCS7Padder{})
+
+	var found []int
+	partial, queries, err := po.DecryptParallel(context.Background(), iv, ctxt, PaddingAttackOpts{
+		Budget: 500,
+		Progress: func(p PaddingProgress) {
+			found = append(found, p.Block*AESBlockSize+p.Pos)
+		},
+	})
+	if !errors.Is(err, ErrQueryBudget) {
+		t.Fatalf("Didn't run out of budget: %s", err)
+	}
+	if queries != 500 {
+		t.Fatalf("Used %d queries", queries)
+	}
+	t.Logf("errored ok: %s", err)
+
+	// What we got before running out is kept
+	padded := PKCS7Padder{}.Pad(msg, AESBlockSize)
+	if len(found) == 0 || len(partial) != len(padded) {
+		t.Fatalf("Recovered %d bytes, partial len %d", len(found), len(partial))
+	}
+	for _, i := range found {
+		if partial[i] != padded[i] {
+			t.Fatalf("Partial byte %d is %d, not %d", i, partial[i], padded[i])
+		}
+	}
+	t.Logf("Kept %d partial bytes", len(found))
+
+	_, _, err = po.DecryptParallel(context.Background(), iv, ctxt, PaddingAttackOpts{Workers: -1})
+	if err == nil {
+		t.Fatalf("Didn't error on negative workers")
+	}
+	t.Logf("errored ok: %s", err)
+
+	slow := PaddingOracle(func(iv, buf []byte) bool {
+		time.Sleep(time.Millisecond)
+		return po(iv, buf)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, _, err = slow.DecryptParallel(ctx, iv, ctxt, PaddingAttackOpts{Workers: 2})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Didn't time out: %s", err)
+	}
+	t.Logf("errored ok: %s", err)
+}
+
+func TestCompleteOrder(t *testing.T) {
+	if len(LikelyBytes) != 256 || LikelyBytes[0] != ' ' {
+		t.Fatalf("Bad LikelyBytes")
+	}
+	order := completeOrder([]byte("zz"))
+	if len(order) != 256 || order[0] != 'z' || order[1] != 0 {
+		t.Fatalf("Bad order %v", order[:3])
+	}
+}
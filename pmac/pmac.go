@@ -0,0 +1,168 @@
+// Package pmac implements PMAC1, Black and Rogaway's parallelizable block
+// cipher MAC.
+//
+// Each block is masked with its own offset and encrypted independently, so
+// the blocks could all be processed at once. Only the sum of the results
+// is encrypted again.
+package pmac
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"math/bits"
+
+	"github.com/jbert/cpals-go/hash"
+)
+
+// PMAC is a hash.Hash, keyed by its block cipher
+type PMAC struct {
+	b cipher.Block
+	// l holds L(i) = L.x^i, for as many i as we have needed
+	l    [][]byte
+	lInv []byte
+	// count is the number of blocks processed so far
+	count  uint64
+	offset []byte
+	sigma  []byte
+	// buf holds the last block, which is treated differently
+	buf []byte
+}
+
+var _ hash.Hash = &PMAC{}
+
+// New creates a PMAC with a 128 bit block cipher
+func New(b cipher.Block) (*PMAC, error) {
+	if b.BlockSize() != 16 {
+		return nil, fmt.Errorf("PMAC needs a 128 bit block, not %d bytes", b.BlockSize())
+	}
+	l := make([]byte, b.BlockSize())
+	b.Encrypt(l, l)
+	p := &PMAC{b: b, l: [][]byte{l}, lInv: half(l)}
+	p.Reset()
+	return p, nil
+}
+
+// NewAES creates PMAC-AES
+func NewAES(key []byte) (*PMAC, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Can't create aes cipher: %w", err)
+	}
+	return New(b)
+}
+
+// double multiplies by x in GF(2^128)
+func double(in []byte) []byte {
+	out := make([]byte, len(in))
+	for i := 0; i < len(in)-1; i++ {
+		out[i] = in[i]<<1 | in[i+1]>>7
+	}
+	out[len(in)-1] = in[len(in)-1] << 1
+	if in[0]&0x80 != 0 {
+		out[len(in)-1] ^= 0x87
+	}
+	return out
+}
+
+// half divides by x, undoing double
+func half(in []byte) []byte {
+	out := make([]byte, len(in))
+	for i := len(in) - 1; i > 0; i-- {
+		out[i] = in[i]>>1 | in[i-1]<<7
+	}
+	out[0] = in[0] >> 1
+	if in[len(in)-1]&1 != 0 {
+		out[0] ^= 0x80
+		out[len(in)-1] ^= 0x43
+	}
+	return out
+}
+
+// lFor returns L(i), extending the table if needed
+func (p *PMAC) lFor(i int) []byte {
+	for len(p.l) <= i {
+		p.l = append(p.l, double(p.l[len(p.l)-1]))
+	}
+	return p.l[i]
+}
+
+func (p *PMAC) Size() int {
+	return p.b.BlockSize()
+}
+
+func (p *PMAC) BlockSize() int {
+	return p.b.BlockSize()
+}
+
+func (p *PMAC) Reset() {
+	p.count = 0
+	p.offset = make([]byte, p.b.BlockSize())
+	p.sigma = make([]byte, p.b.BlockSize())
+	p.buf = p.buf[:0]
+}
+
+// processBlock adds in a block which isn't the last. Block i's offset is
+// the previous one XOR L(ntz(i)), so offsets follow a Gray code.
+func (p *PMAC) processBlock(block []byte) {
+	p.count++
+	l := p.lFor(bits.TrailingZeros64(p.count))
+	tmp := make([]byte, len(block))
+	for i := range p.offset {
+		p.offset[i] ^= l[i]
+		tmp[i] = block[i] ^ p.offset[i]
+	}
+	p.b.Encrypt(tmp, tmp)
+	for i := range p.sigma {
+		p.sigma[i] ^= tmp[i]
+	}
+}
+
+func (p *PMAC) Write(in []byte) (int, error) {
+	bs := p.b.BlockSize()
+	n := len(in)
+	for len(in) > 0 {
+		if len(p.buf) == bs {
+			p.processBlock(p.buf)
+			p.buf = p.buf[:0]
+		}
+		used := bs - len(p.buf)
+		if used > len(in) {
+			used = len(in)
+		}
+		p.buf = append(p.buf, in[:used]...)
+		in = in[used:]
+	}
+	return n, nil
+}
+
+func (p *PMAC) MustWrite(in []byte) {
+	n, err := p.Write(in)
+	if n != len(in) {
+		err = fmt.Errorf("Wrote %d bytes to hash, not %d", n, len(in))
+	}
+	if err != nil {
+		panic(fmt.Sprintf("Can't write to hash: %s", err))
+	}
+}
+
+// Sum appends the tag to in. A whole last block is XORed with L.x^-1, a
+// partial one is padded with 0x80 then zeros; either is added to the sum
+// unencrypted.
+func (p *PMAC) Sum(in []byte) []byte {
+	bs := p.b.BlockSize()
+	last := make([]byte, bs)
+	copy(last, p.buf)
+	if len(p.buf) == bs {
+		for i := range last {
+			last[i] ^= p.lInv[i]
+		}
+	} else {
+		last[len(p.buf)] = 0x80
+	}
+	for i := range last {
+		last[i] ^= p.sigma[i]
+	}
+	p.b.Encrypt(last, last)
+	return append(in, last...)
+}
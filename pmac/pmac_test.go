@@ -0,0 +1,60 @@
+package pmac
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func mustDeHex(t *testing.T, h string) []byte {
+	buf, err := hex.DecodeString(h)
+	if err != nil {
+		t.Fatalf("Bad hex [%s]: %s", h, err)
+	}
+	return buf
+}
+
+// PMAC-AES-128 vectors, with key 000102...0f and message 000102...
+func TestKnownAnswers(t *testing.T) {
+	p, err := NewAES(mustDeHex(t, "000102030405060708090a0b0c0d0e0f"))
+	if err != nil {
+		t.Fatalf("Can't create PMAC: %s", err)
+	}
+	msg := make([]byte, 34)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	testCases := []struct {
+		msgLen   int
+		expected string
+	}{
+		{0, "4399572cd6ea5341b8d35876a7098af7"},
+		{3, "256ba5193c1b991b4df0c51f388a9e27"},
+		{16, "ebbd822fa458daf6dfdad7c27da76338"},
+		{20, "0412ca150bbf79058d8c75a58c993f55"},
+		{32, "e97ac04e9e5e3399ce5355cd7407bc75"},
+		{34, "5cba7d5eb24f7c86ccc54604e53d5512"},
+	}
+	for _, tc := range testCases {
+		p.Reset()
+		for i := 0; i < tc.msgLen; i += 7 {
+			end := i + 7
+			if end > tc.msgLen {
+				end = tc.msgLen
+			}
+			p.MustWrite(msg[i:end])
+		}
+		got := hex.EncodeToString(p.Sum(nil))
+		if got != tc.expected {
+			t.Fatalf("%d: got %s expected %s", tc.msgLen, got, tc.expected)
+		}
+	}
+}
+
+func TestHalf(t *testing.T) {
+	for _, h := range []string{"00000000000000000000000000000001", "80000000000000000000000000000000", "0123456789abcdef0123456789abcdef"} {
+		l := mustDeHex(t, h)
+		if hex.EncodeToString(double(half(l))) != h || hex.EncodeToString(half(double(l))) != h {
+			t.Fatalf("half doesn't undo double for %s", h)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package cpals
+
+import (
+	"math"
+	"sort"
+)
+
+// KeystreamPair holds the statistics of the XOR of two ciphertexts over
+// their common length. If they share a keystream, that XOR is the XOR of
+// the plaintexts, which is far from uniformly random.
+type KeystreamPair struct {
+	A, B    int
+	Overlap int
+	// Fraction of XOR bytes with the high bit clear. ASCII ^ ASCII always
+	// has, random bytes half the time.
+	HighBitClear float64
+	// Index of coincidence of the XOR bytes relative to random bytes
+	IndexOfCoincidence float64
+	// Probability-like score in [0, 1] that the keystream is shared
+	Confidence float64
+}
+
+// KeystreamGroup is a set of ciphertexts (by index) which probably share
+// a keystream
+type KeystreamGroup struct {
+	Members []int
+	// Mean pair confidence over all pairs of members
+	Confidence float64
+}
+
+type KeystreamReuseDetector struct {
+	// Pairs with fewer common bytes than this are not compared. Defaults to 16
+	MinOverlap int
+	// Pairs at or above this confidence are linked. Defaults to 1 - 2^-n
+	// for a pair overlapping by n bytes, but no more than 1 - 1e-12. A
+	// short pair can't give enough evidence to pass a higher fixed
+	// threshold.
+	Threshold float64
+}
+
+func DetectKeystreamReuse(ctxts [][]byte) []KeystreamGroup {
+	return KeystreamReuseDetector{}.Detect(ctxts)
+}
+
+func (d KeystreamReuseDetector) defaults() KeystreamReuseDetector {
+	if d.MinOverlap == 0 {
+		d.MinOverlap = 16
+	}
+	return d
+}
+
+// threshold is the confidence needed to link a pair with this overlap
+func (d KeystreamReuseDetector) threshold(overlap int) float64 {
+	if d.Threshold != 0 {
+		return d.Threshold
+	}
+	return 1 - math.Max(math.Pow(2, -float64(overlap)), 1e-12)
+}
+
+// ComparePair computes the statistics for one pair of ciphertexts
+func ComparePair(a, b []byte) KeystreamPair {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	p := KeystreamPair{Overlap: n}
+	if n < 2 {
+		return p
+	}
+
+	clear := 0
+	var counts [256]int
+	for i := 0; i < n; i++ {
+		x := a[i] ^ b[i]
+		if x&0x80 == 0 {
+			clear++
+		}
+		counts[x]++
+	}
+	coincidences := 0.0
+	for _, c := range counts {
+		coincidences += float64(c) * float64(c-1) / 2
+	}
+	numPairs := float64(n) * float64(n-1) / 2
+	expectedCoincidences := numPairs / 256
+
+	p.HighBitClear = float64(clear) / float64(n)
+	p.IndexOfCoincidence = coincidences / expectedCoincidences
+
+	// For random bytes, the number of clear high bits is binomial and the
+	// number of coincidences roughly Poisson. Combine how surprising each
+	// is with Fisher's method (chi-squared with 4 degrees of freedom).
+	pHighBit := binomialUpperTail(n, clear)
+	pIoC := poissonUpperTail(expectedCoincidences, int(coincidences))
+	x := -2 * (logFloor(pHighBit) + logFloor(pIoC))
+	p.Confidence = 1 - math.Exp(-x/2)*(1+x/2)
+	return p
+}
+
+func logFloor(p float64) float64 {
+	if p < math.SmallestNonzeroFloat64 {
+		p = math.SmallestNonzeroFloat64
+	}
+	return math.Log(p)
+}
+
+// sumUpperTail adds exp(logTerm(k)) for k from lo up to hi, stopping once
+// the terms are negligible. Terms must be decreasing from lo.
+func sumUpperTail(lo, hi int, logTerm func(k int) float64) float64 {
+	sum := 0.0
+	for k := lo; k <= hi; k++ {
+		term := math.Exp(logTerm(k))
+		sum += term
+		if term < sum*1e-12 {
+			break
+		}
+	}
+	if sum > 1 {
+		sum = 1
+	}
+	return sum
+}
+
+// binomialUpperTail is P(X >= k) for X ~ Binomial(n, 1/2)
+func binomialUpperTail(n, k int) float64 {
+	if 2*k <= n {
+		// Not surprising, and the terms aren't decreasing
+		return 1
+	}
+	lgN, _ := math.Lgamma(float64(n + 1))
+	return sumUpperTail(k, n, func(i int) float64 {
+		lgI, _ := math.Lgamma(float64(i + 1))
+		lgNI, _ := math.Lgamma(float64(n - i + 1))
+		return lgN - lgI - lgNI - float64(n)*math.Ln2
+	})
+}
+
+// poissonUpperTail is P(X >= k) for X ~ Poisson(mean)
+func poissonUpperTail(mean float64, k int) float64 {
+	if float64(k) <= mean {
+		return 1
+	}
+	return sumUpperTail(k, math.MaxInt32, func(i int) float64 {
+		lgI, _ := math.Lgamma(float64(i + 1))
+		return -mean + float64(i)*math.Log(mean) - lgI
+	})
+}
+
+// Pairs compares every pair of ciphertexts with enough overlap
+func (d KeystreamReuseDetector) Pairs(ctxts [][]byte) []KeystreamPair {
+	d = d.defaults()
+	var pairs []KeystreamPair
+	for i := range ctxts {
+		for j := i + 1; j < len(ctxts); j++ {
+			p := ComparePair(ctxts[i], ctxts[j])
+			if p.Overlap < d.MinOverlap {
+				continue
+			}
+			p.A, p.B = i, j
+			pairs = append(pairs, p)
+		}
+	}
+	return pairs
+}
+
+// Detect clusters the ciphertexts into groups linked by confident pairs.
+// Only groups of two or more are returned, most confident first.
+func (d KeystreamReuseDetector) Detect(ctxts [][]byte) []KeystreamGroup {
+	d = d.defaults()
+	pairs := d.Pairs(ctxts)
+
+	parent := make([]int, len(ctxts))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	confidence := make(map[[2]int]float64)
+	for _, p := range pairs {
+		confidence[[2]int{p.A, p.B}] = p.Confidence
+		if p.Confidence >= d.threshold(p.Overlap) {
+			parent[find(p.A)] = find(p.B)
+		}
+	}
+
+	members := make(map[int][]int)
+	for i := range ctxts {
+		root := find(i)
+		members[root] = append(members[root], i)
+	}
+
+	var groups []KeystreamGroup
+	for _, m := range members {
+		if len(m) < 2 {
+			continue
+		}
+		total := 0.0
+		n := 0
+		for i := range m {
+			for j := i + 1; j < len(m); j++ {
+				// Pairs too short to compare count as zero
+				total += confidence[[2]int{m[i], m[j]}]
+				n++
+			}
+		}
+		groups = append(groups, KeystreamGroup{m, total / float64(n)})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Confidence != groups[j].Confidence {
+			return groups[i].Confidence > groups[j].Confidence
+		}
+		return groups[i].Members[0] < groups[j].Members[0]
+	})
+	return groups
+}
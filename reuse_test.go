@@ -0,0 +1,115 @@
+package cpals
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDetectKeystreamReuse(t *testing.T) {
+	lines := bytes.Split(bytes.TrimSpace(Hamlet), []byte("\n"))
+
+	var ctxts [][]byte
+	expected := make(map[int]int)
+	add := func(group int, ct []byte) {
+		if group >= 0 {
+			expected[len(ctxts)] = group
+		}
+		ctxts = append(ctxts, ct)
+	}
+
+	ctrKey := RandomKey()
+	mtSeed := uint32(1234)
+	for i := 0; i < 18; i++ {
+		line := lines[i]
+		switch i % 6 {
+		case 0, 1, 2:
+			add(0, AESCTR(ctrKey, 0, line))
+		case 3:
+			add(1, MTStream(mtSeed, line))
+		case 4:
+			// Fresh nonce each time - no reuse
+			add(-1, AESCTR(ctrKey, int64(i), line))
+		case 5:
+			add(-1, RandomBytes(len(line)))
+		}
+	}
+
+	groups := DetectKeystreamReuse(ctxts)
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %d: %v", len(groups), groups)
+	}
+	for _, g := range groups {
+		want := expected[g.Members[0]]
+		for _, m := range g.Members {
+			got, ok := expected[m]
+			if !ok || got != want {
+				t.Fatalf("Ciphertext %d wrongly in group %v", m, g.Members)
+			}
+		}
+		t.Logf("Group %v confidence %f", g.Members, g.Confidence)
+	}
+	total := 0
+	for _, g := range groups {
+		total += len(g.Members)
+	}
+	if total != len(expected) {
+		t.Fatalf("Grouped %d ciphertexts, expected %d", total, len(expected))
+	}
+}
+
+func TestComparePair(t *testing.T) {
+	a := []byte("The slings and arrows of outrageous fortune")
+	b := []byte("Or to take arms against a sea of troubles")
+	ks := RandomBytes(len(a))
+	ca, _ := Xor(a, ks)
+	cb, _ := Xor(b, ks[:len(b)])
+
+	p := ComparePair(ca, cb)
+	if p.HighBitClear != 1.0 {
+		t.Fatalf("ASCII pair should have all high bits clear, got %f", p.HighBitClear)
+	}
+	if p.Confidence < 1-1e-12 {
+		t.Fatalf("Shared keystream pair has low confidence %f", p.Confidence)
+	}
+
+	p = ComparePair(RandomBytes(len(a)), RandomBytes(len(b)))
+	if p.Confidence > 1-1e-12 {
+		t.Fatalf("Random pair has high confidence %f", p.Confidence)
+	}
+}
+
+func TestDetectKeystreamReuseShort(t *testing.T) {
+	var long [][]byte
+	for _, line := range bytes.Split(bytes.TrimSpace(Hamlet), []byte("\n")) {
+		if len(line) >= 32 {
+			long = append(long, line)
+		}
+	}
+
+	// Overlaps of 16 to 32 bytes, with random and fresh nonce
+	// ciphertexts of the same lengths
+	var ctxts [][]byte
+	shared := make(map[int]bool)
+	ctrKey := RandomKey()
+	for i := 0; i < 9; i++ {
+		n := 16 + 2*i
+		shared[len(ctxts)] = true
+		ctxts = append(ctxts, AESCTR(ctrKey, 0, long[i][:n]))
+		ctxts = append(ctxts, AESCTR(ctrKey, int64(i+1), long[i+9][:n]))
+		ctxts = append(ctxts, RandomBytes(n))
+	}
+
+	groups := DetectKeystreamReuse(ctxts)
+	if len(groups) != 1 {
+		t.Fatalf("Expected 1 group, got %d: %v", len(groups), groups)
+	}
+	if len(groups[0].Members) != len(shared) {
+		t.Fatalf("Group %v, expected %d members", groups[0].Members, len(shared))
+	}
+	for _, m := range groups[0].Members {
+		if !shared[m] {
+			t.Fatalf("Ciphertext %d wrongly in group %v", m, groups[0].Members)
+		}
+	}
+	t.Logf("Group %v confidence %f", groups[0].Members, groups[0].Confidence)
+}
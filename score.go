@@ -0,0 +1,245 @@
+package cpals
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Scorer rates how much a candidate plaintext looks like the real thing.
+// Higher scores are better.
+type Scorer interface {
+	Score(msg []byte) float64
+}
+
+type ScorerFunc func(msg []byte) float64
+
+func (f ScorerFunc) Score(msg []byte) float64 {
+	return f(msg)
+}
+
+// EnglishScorer is the original hand-tuned letter boost scorer
+var EnglishScorer Scorer = ScorerFunc(EnglishScore)
+
+// NGramModel holds n-gram frequencies trained from a corpus. Letters are
+// case-folded before counting, everything else is counted as-is.
+type NGramModel struct {
+	n      int
+	counts map[string]float64
+	total  float64
+}
+
+func NewNGramModel(n int) *NGramModel {
+	if n < 1 {
+		panic(fmt.Sprintf("Bad n-gram size: %d", n))
+	}
+	return &NGramModel{
+		n:      n,
+		counts: make(map[string]float64),
+	}
+}
+
+func TrainNGramModel(n int, corpus []byte) *NGramModel {
+	m := NewNGramModel(n)
+	m.Train(corpus)
+	return m
+}
+
+func TrainNGramModelFromFile(n int, fname string) (*NGramModel, error) {
+	corpus, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read corpus [%s]: %w", fname, err)
+	}
+	return TrainNGramModel(n, corpus), nil
+}
+
+func (m *NGramModel) N() int {
+	return m.n
+}
+
+func (m *NGramModel) Train(corpus []byte) {
+	corpus = foldCase(corpus)
+	for i := 0; i+m.n <= len(corpus); i++ {
+		m.counts[string(corpus[i:i+m.n])]++
+		m.total++
+	}
+}
+
+// LogProb is the log10 probability of the gram. Grams never seen in
+// training get a floor probability well below anything which was seen.
+func (m *NGramModel) LogProb(gram []byte) float64 {
+	return m.logProb(string(foldCase(gram)))
+}
+
+func (m *NGramModel) logProb(gram string) float64 {
+	if m.total == 0 {
+		return m.floor()
+	}
+	c, ok := m.counts[gram]
+	if !ok {
+		return m.floor()
+	}
+	return math.Log10(c / m.total)
+}
+
+func (m *NGramModel) floor() float64 {
+	return math.Log10(0.01 / (m.total + 1))
+}
+
+func (m *NGramModel) grams(msg []byte) []string {
+	msg = foldCase(msg)
+	var gs []string
+	for i := 0; i+m.n <= len(msg); i++ {
+		gs = append(gs, string(msg[i:i+m.n]))
+	}
+	return gs
+}
+
+// Save writes the model in a simple line-based text format which
+// ReadNGramModel can load.
+func (m *NGramModel) Save(fname string) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return fmt.Errorf("Can't create file [%s]: %w", fname, err)
+	}
+	err = m.Write(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (m *NGramModel) Write(w io.Writer) error {
+	keys := make([]string, 0, len(m.counts))
+	for k := range m.counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "ngram %d\n", m.n)
+	for _, k := range keys {
+		fmt.Fprintf(bw, "%s %s\n", EnHex([]byte(k)), strconv.FormatFloat(m.counts[k], 'g', -1, 64))
+	}
+	return bw.Flush()
+}
+
+func LoadNGramModel(fname string) (*NGramModel, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("Can't open file [%s]: %w", fname, err)
+	}
+	defer f.Close()
+
+	return ReadNGramModel(f)
+}
+
+func ReadNGramModel(r io.Reader) (*NGramModel, error) {
+	lines, err := ReadLines(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("Empty model")
+	}
+	var n int
+	_, err = fmt.Sscanf(lines[0], "ngram %d", &n)
+	if err != nil {
+		return nil, fmt.Errorf("Bad model header [%s]: %w", lines[0], err)
+	}
+	if n < 1 {
+		return nil, fmt.Errorf("Bad n-gram size: %d", n)
+	}
+	m := NewNGramModel(n)
+	for i, l := range lines[1:] {
+		fields := strings.Fields(l)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Line %d: expected 2 fields, got %d", i+2, len(fields))
+		}
+		gram, err := DeHex(HexStr(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: bad gram: %w", i+2, err)
+		}
+		if len(gram) != n {
+			return nil, fmt.Errorf("Line %d: gram length %d != %d", i+2, len(gram), n)
+		}
+		count, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: bad count: %w", i+2, err)
+		}
+		m.counts[string(gram)] = count
+		m.total += count
+	}
+	return m, nil
+}
+
+// LogLikelihoodScorer scores by the mean log probability of each n-gram in
+// the message.
+type LogLikelihoodScorer struct {
+	*NGramModel
+}
+
+func (s LogLikelihoodScorer) Score(msg []byte) float64 {
+	gs := s.grams(msg)
+	if len(gs) == 0 {
+		return s.floor()
+	}
+	score := 0.0
+	for _, g := range gs {
+		score += s.logProb(g)
+	}
+	return score / float64(len(gs))
+}
+
+// ChiSquaredScorer compares the observed n-gram counts against those
+// expected from the model. The statistic is negated (and normalised by
+// message length) so that higher is better.
+type ChiSquaredScorer struct {
+	*NGramModel
+}
+
+func (s ChiSquaredScorer) Score(msg []byte) float64 {
+	gs := s.grams(msg)
+	if len(gs) == 0 || s.total == 0 {
+		return math.Inf(-1)
+	}
+	numGrams := float64(len(gs))
+	observed := make(map[string]float64)
+	for _, g := range gs {
+		observed[g]++
+	}
+
+	// Every gram in the model which we didn't observe contributes
+	// (0-e)^2/e = e, so we only need to walk the observed grams and then
+	// account for the expected count we haven't yet seen.
+	chi2 := 0.0
+	expectedSeen := 0.0
+	for g, o := range observed {
+		e := math.Pow(10, s.logProb(g)) * numGrams
+		if _, ok := s.counts[g]; ok {
+			expectedSeen += e
+		}
+		chi2 += (o - e) * (o - e) / e
+	}
+	chi2 += numGrams - expectedSeen
+	return -chi2 / numGrams
+}
+
+func foldCase(buf []byte) []byte {
+	ret := make([]byte, len(buf))
+	for i, c := range buf {
+		if c >= 'A' && c <= 'Z' {
+			c = ByteLowerCase(c)
+		}
+		ret[i] = c
+	}
+	return ret
+}
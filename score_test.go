@@ -0,0 +1,80 @@
+package cpals
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestNGramScorers(t *testing.T) {
+	english := []byte("Now is the winter of our discontent made glorious summer")
+	scorers := make(map[string]Scorer)
+	scorers["english"] = EnglishScorer
+	for n := 1; n <= 3; n++ {
+		m := TrainNGramModel(n, Hamlet)
+		scorers[fmt.Sprintf("chi2-%d", n)] = ChiSquaredScorer{m}
+		scorers[fmt.Sprintf("loglik-%d", n)] = LogLikelihoodScorer{m}
+	}
+
+	for name, scorer := range scorers {
+		t.Run(name, func(t *testing.T) {
+			englishScore := scorer.Score(english)
+			randomScore := scorer.Score(RandomBytes(len(english)))
+			if englishScore <= randomScore {
+				t.Fatalf("English %f <= random %f", englishScore, randomScore)
+			}
+
+			key := byte(0x5a)
+			msg, _, b := SolveSingleByteXor(XorByte(english, key), scorer)
+			if b != key {
+				t.Fatalf("Got key %02X expected %02X: %s", b, key, msg)
+			}
+		})
+	}
+}
+
+func TestNGramUpperCase(t *testing.T) {
+	shouty := []byte("THE READINESS IS ALL")
+	key := byte(0x20)
+	ctxt := XorByte(shouty, key)
+
+	scorer := LogLikelihoodScorer{TrainNGramModel(2, Hamlet)}
+	msg, _, b := SolveSingleByteXor(ctxt, scorer)
+	if b != key && b != 0 {
+		t.Fatalf("Got key %02X: %s", b, msg)
+	}
+	if !bytes.EqualFold(msg, shouty) {
+		t.Fatalf("Got %s expected %s", msg, shouty)
+	}
+	t.Logf("Recovered: %s", msg)
+}
+
+func TestNGramModelSaveLoad(t *testing.T) {
+	m := TrainNGramModel(3, Hamlet)
+
+	w := &bytes.Buffer{}
+	err := m.Write(w)
+	if err != nil {
+		t.Fatalf("Can't write model: %s", err)
+	}
+	loaded, err := ReadNGramModel(w)
+	if err != nil {
+		t.Fatalf("Can't read model: %s", err)
+	}
+	if loaded.N() != m.N() {
+		t.Fatalf("Got n %d expected %d", loaded.N(), m.N())
+	}
+
+	msg := []byte("Whether 'tis nobler in the mind")
+	got := LogLikelihoodScorer{loaded}.Score(msg)
+	expected := LogLikelihoodScorer{m}.Score(msg)
+	if got != expected {
+		t.Fatalf("Loaded model scores %f, original %f", got, expected)
+	}
+
+	_, err = ReadNGramModel(bytes.NewBufferString("ngram 2\n414243 1\n"))
+	if err == nil {
+		t.Fatalf("Didn't error on wrong gram length")
+	}
+	t.Logf("Wrong gram length errored ok: %s", err)
+}
@@ -1,8 +1,11 @@
 package cpals
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/jbert/cpals-go/hmac"
@@ -84,3 +87,107 @@ func (cs *C31Server) MustStart() {
 		}
 	}()
 }
+
+// LeakMode says how a PaddingOracleServer gives away padding validity
+type LeakMode int
+
+const (
+	// LeakStatus returns 500 for bad padding, and 403 for a good padding
+	// but bad session
+	LeakStatus LeakMode = iota
+	// LeakBody returns 403 for both, but says which in the body
+	LeakBody
+	// LeakTiming returns the same thing for both, but only looks up the
+	// session, which is slow, when the padding is good
+	LeakTiming
+)
+
+// PaddingOracleServer is a web app which keeps its sessions in an AES-CBC
+// encrypted cookie, and lets slip whether the padding was good
+type PaddingOracleServer struct {
+	*http.Server
+	key  []byte
+	mode LeakMode
+	// LookupDelay is how long the session lookup takes in LeakTiming mode
+	LookupDelay time.Duration
+}
+
+// PaddingOracleCookie is the name of the session cookie
+const PaddingOracleCookie = "session"
+
+func NewPaddingOracleServer(port int, mode LeakMode) *PaddingOracleServer {
+	ps := PaddingOracleServer{
+		key:         RandomKey(),
+		mode:        mode,
+		LookupDelay: 20 * time.Millisecond,
+	}
+	sm := http.NewServeMux()
+	sm.HandleFunc("/", ps.Handler)
+	ps.Server = &http.Server{
+		Addr:           fmt.Sprintf(":%d", port),
+		Handler:        sm,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxHeaderBytes: 1 << 20,
+	}
+	return &ps
+}
+
+// NewSession logs user in, returning the session cookie value: the
+// base64 of the IV then the ciphertext
+func (ps *PaddingOracleServer) NewSession(user string) string {
+	iv := RandomBytes(AESBlockSize)
+	ctxt := AESCBCEncrypt(ps.key, iv, []byte("user="+user))
+	return base64.StdEncoding.EncodeToString(append(iv, ctxt...))
+}
+
+func (ps *PaddingOracleServer) session(cookie string) (string, error) {
+	buf, err := base64.StdEncoding.DecodeString(cookie)
+	if err != nil || len(buf) < 2*AESBlockSize {
+		return "", errors.New("Malformed cookie")
+	}
+	msg, err := AESCBCDecryptErr(ps.key, buf[:AESBlockSize], buf[AESBlockSize:])
+	if err != nil {
+		return "", errPadding
+	}
+	if ps.mode == LeakTiming {
+		time.Sleep(ps.LookupDelay)
+	}
+	s := string(msg)
+	if !strings.HasPrefix(s, "user=") {
+		return "", errors.New("Not logged in")
+	}
+	return strings.TrimPrefix(s, "user="), nil
+}
+
+var errPadding = errors.New("Invalid padding")
+
+func (ps *PaddingOracleServer) Handler(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(PaddingOracleCookie)
+	if err != nil {
+		http.Error(w, "Not logged in", http.StatusForbidden)
+		return
+	}
+	user, err := ps.session(c.Value)
+	if err != nil {
+		switch {
+		case ps.mode == LeakStatus && err == errPadding:
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		case ps.mode == LeakBody && err == errPadding:
+			http.Error(w, "Invalid padding", http.StatusForbidden)
+		default:
+			http.Error(w, "Not logged in", http.StatusForbidden)
+		}
+		return
+	}
+	fmt.Fprintf(w, "Hello %s\n", user)
+}
+
+func (ps *PaddingOracleServer) MustStart() {
+	go func() {
+		err := ps.ListenAndServe()
+		if err != http.ErrServerClosed {
+			panic(fmt.Sprintf("Server error: %s", err))
+		}
+	}()
+}
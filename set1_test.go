@@ -1,25 +1,31 @@
 package cpals
 
 import (
-	"sort"
+	"os"
 	"testing"
 )
 
 func TestS1C8(t *testing.T) {
 	fname := "8.txt"
-	lines, err := LoadLines(fname)
+	f, err := os.Open(fname)
 	if err != nil {
-		t.Fatalf("Can't load lines from file [%s]: %s", fname, err)
+		t.Fatalf("Can't open file [%s]: %s", fname, err)
 	}
+	defer f.Close()
 
-	blockSize := 16
-	for i, l := range lines {
-		buf, _ := DeHex(HexStr(l))
-		dup := BytesFindDuplicateBlock(buf, blockSize)
-		if dup != nil {
-			t.Logf("Line %d has duplicate blocks\n", i)
-		}
+	reports, err := DetectECB(f)
+	if err != nil {
+		t.Fatalf("Can't detect ECB: %s", err)
 	}
+	if len(reports) != 1 {
+		t.Fatalf("Expected one ECB line, got %d", len(reports))
+	}
+	r := reports[0]
+	best := r.Best()
+	if best.BlockSize != AESBlockSize {
+		t.Fatalf("Got block size %d expected %d", best.BlockSize, AESBlockSize)
+	}
+	t.Logf("Line %d has %d repeated blocks: %v\n", r.Record, best.Repeats, best.Duplicates[0].Positions)
 }
 
 func TestS1C7(t *testing.T) {
@@ -47,38 +53,11 @@ func TestS1C6(t *testing.T) {
 	}
 	//	t.Logf("Read buf: %v", buf)
 
-	keySizes := GuessXorKeySize(buf)
-
-	type sizeScore struct {
-		keySize int
-		score   float64
-		msg     []byte
-	}
-
-	numToExamine := 10
-	sizeScores := make([]sizeScore, numToExamine)
-	for i, keySize := range keySizes[0:numToExamine] {
-		//		t.Logf("Key size: %d", keySize)
-		chunks, _ := BytesToChunks(buf, keySize)
-		chunks = ChunksTranspose(chunks)
-		if len(chunks) != keySize {
-			t.Fatalf("WTF")
-		}
-		key := make([]byte, keySize)
-		for i := range key {
-			_, _, b := SolveEnglishSingleByteXor(chunks[i])
-			key[i] = b
-		}
-		msg := XorKey(buf, key)
-		englishScore := EnglishScore(msg)
-		sizeScores[i] = sizeScore{keySize, englishScore, msg}
-		//		t.Logf("Keysize: %d Score %f\n%s\n", sizeScores[i].keySize, sizeScores[i].score, sizeScores[i].msg)
+	res, err := BreakRepeatingKeyXor(buf, RepeatingKeyXorOpts{})
+	if err != nil {
+		t.Fatalf("Can't break repeating key xor: %s", err)
 	}
-
-	sort.Slice(sizeScores, func(i, j int) bool {
-		return sizeScores[i].score > sizeScores[j].score
-	})
-	t.Logf("Keysize: %d Score: %f\n%s\n", sizeScores[0].keySize, sizeScores[0].score, sizeScores[0].msg)
+	t.Logf("Key: [%s] Score: %f Confidence: %f\n%s\n", res.Key, res.Score, res.Confidence, res.PlainText)
 }
 
 func TestS1C5(t *testing.T) {
@@ -41,7 +41,10 @@ func TestS2C16(t *testing.T) {
 	}
 	t.Log("Got error return for bad padding")
 
-	blockSize := C16Encode.FindBlockSize()
+	blockSize, err := C16Encode.FindBlockSize()
+	if err != nil {
+		t.Fatalf("Can't find block size: %s", err)
+	}
 	t.Logf("Blocksize is %d", blockSize)
 
 	/*
@@ -152,108 +155,15 @@ func TestS2C15(t *testing.T) {
 }
 
 func TestS2C14(t *testing.T) {
-	//	...as for C12 but we need to loop when biulding the dict so we get blockSize candidates for each byte...
 	C14EncryptionOracle := Oracle(C14EncryptionOracleFunc)
-
-	blockSize := C14EncryptionOracle.FindBlockSize()
-	t.Logf("Enc oracle has block size: %d", blockSize)
-	isECB := C14EncryptionOracle.IsECB(blockSize)
-	t.Logf("Enc oracle is ECB: %v", isECB)
-
-	blockAfterDuplicates := func(buf []byte, blockSize int) (bool, []byte) {
-		chunks, _ := BytesToChunks(buf, blockSize)
-		var lastChunk []byte
-		lastDup := false
-		for _, c := range chunks {
-			if lastDup {
-				return true, c
-			}
-			if lastChunk != nil {
-				if BytesEqual(lastChunk, c) {
-					lastDup = true
-				}
-			}
-			lastChunk = c
-		}
-		return false, nil
-	}
-
-	makeDict := func(targetBlock []byte) map[string]byte {
-		blockDict := make(map[string]byte)
-		workBlock := make([]byte, len(targetBlock)+1)
-		copy(workBlock, targetBlock)
-		for b := 0; b <= 0xff; b++ {
-		FIND_DUP:
-			for {
-				workBlock[len(workBlock)-1] = byte(b)
-
-				// Prefix work block with 2 identical blocks
-				// We know if we get identical blocks in output, we were aligned
-				chosenMsg := NewBytes(blockSize, 'B')
-				chosenMsg = append(chosenMsg, NewBytes(2*blockSize, 'A')...)
-				chosenMsg = append(chosenMsg, workBlock...)
-				buf := C14EncryptionOracle(chosenMsg)
-
-				// If we t have duplicates, we want
-				hasDuplicate, blockAfter := blockAfterDuplicates(buf, blockSize)
-				if hasDuplicate {
-					blockDict[string(blockAfter)] = byte(b)
-					break FIND_DUP
-				}
-			}
-		}
-		return blockDict
+	msg, err := C14EncryptionOracle.DecryptSuffix()
+	if err != nil {
+		t.Fatalf("Can't decrypt suffix: %s", err)
 	}
-
-	knownMsg := []byte{}
-NEXT_CHARACTER:
-	for {
-		lenPadding := ((blockSize - 1) - len(knownMsg)) % blockSize
-		if lenPadding < 0 {
-			lenPadding += blockSize
-		}
-		padChunk := make([]byte, lenPadding)
-
-		// Loop until we see duplicate blocks
-		var buf []byte
-		chosenMsg := NewBytes(blockSize, 'B')
-		chosenMsg = append(chosenMsg, NewBytes(2*blockSize, 'A')...)
-		chosenMsg = append(chosenMsg, padChunk...)
-	TRY_ALIGNMENT:
-		for {
-			buf = C14EncryptionOracle(chosenMsg)
-			if HasDuplicateBlocks(buf, blockSize) {
-				break TRY_ALIGNMENT
-			}
-		}
-
-		// We want last blockSize-1 bytes of knownMsg
-		targetBlock := make([]byte, blockSize-1)
-		offset := len(knownMsg) - (blockSize - 1)
-		if offset < 0 {
-			copy(targetBlock[-offset:], knownMsg)
-		} else {
-			copy(targetBlock, knownMsg[len(knownMsg)-(blockSize-1):])
-		}
-
-		blockDict := makeDict(targetBlock)
-
-		chunks, slop := BytesToChunks(buf, blockSize)
-		if len(slop) != 0 {
-			panic(fmt.Sprintf("wtf - found slop %v", slop))
-		}
-
-		for _, chunk := range chunks {
-			b, ok := blockDict[string(chunk)]
-			if ok {
-				knownMsg = append(knownMsg, b)
-				//				t.Logf("%d MSG: %s\n", len(knownMsg), string(knownMsg))
-				continue NEXT_CHARACTER
-			}
-		}
-		break NEXT_CHARACTER
+	if !strings.HasPrefix(string(msg), "Rollin' in my 5.0") {
+		t.Fatalf("Wrong suffix: %q", msg)
 	}
-	t.Logf("MSG: %s\n", string(knownMsg))
+	t.Logf("MSG: %s\n", string(msg))
 }
 
 func C14EncryptionOracleFunc(msg []byte) []byte {
@@ -266,61 +176,53 @@ func C14EncryptionOracleFunc(msg []byte) []byte {
 }
 
 func TestS2C13(t *testing.T) {
-	// Want to switch out a block 'userPADDING' with 'admin&'
-	// have an email ending in 'admin', so get a sep at end
-	// save blocks at all offsets
-
-	cryptor := func(buf []byte) []byte {
+	// The oracle wraps our email in a profile, so we cut blocks out of
+	// profiles and paste them in to one with an admin role
+	oracle := Oracle(func(buf []byte) []byte {
 		return C13EncryptedProfileFor(string(buf))
-	}
-	blockSize, pkcsFullPadBlock := FindBlockSizeAndFullPadBlock(cryptor)
-	t.Logf("block size is %d\n", blockSize)
-
-	//target := []byte("admin")
-	target := []byte("admin&foo=123456")
-	savedBlocks := make([][]byte, 0)
-	for i := 0; i < blockSize; i++ {
-		in := make([]byte, i+len(target))
-		copy(in[i:], target)
-		buf := C13EncryptedProfileFor(string(in))
-		chunks, slop := BytesToChunks(buf, blockSize)
-		if len(slop) != 0 {
-			panic("badness")
-		}
-		for _, c := range chunks {
-			savedBlocks = append(savedBlocks, c)
-		}
+	})
+	cp, err := NewCutAndPaste(oracle, C13Template)
+	if err != nil {
+		t.Fatalf("Can't create cut and paste: %s", err)
 	}
 
-	t.Logf("Got %d saved blocks", len(savedBlocks))
-
-	var up UserProfile
-	var err error
-	found := false
-	email := "evil@example.com"
-FINISHED:
-	for i := 0; i < blockSize; i++ {
-		playBuf := C13EncryptedProfileFor(email)
-		playBuf = append(playBuf, pkcsFullPadBlock...)
-		for _, c := range savedBlocks {
-			copy(playBuf[len(playBuf)-2*blockSize:], c)
-			up, err = C13DecryptProfile(playBuf)
-			if err != nil {
-				continue
-			}
-			if up.role == "admin" {
-				found = true
-				break FINISHED
-			}
-		}
-		email = "A" + email
+	target := []byte("email=evil@example.com&uid=10&role=admin")
+	plan, err := cp.Plan(target)
+	if err != nil {
+		t.Fatalf("Can't plan target: %s", err)
+	}
+	for i, piece := range plan {
+		t.Logf("Block %d: block %d of %q", i, piece.Block, piece.Input)
 	}
+	forged, err := cp.Forge(target)
+	if err != nil {
+		t.Fatalf("Can't forge target: %s", err)
+	}
+	up, err := C13DecryptProfile(forged)
+	if err != nil {
+		t.Fatalf("Can't decrypt forged profile: %s", err)
+	}
+	if up.role != "admin" {
+		t.Fatalf("Not admin: %s", up.Encode())
+	}
+	t.Logf("<voice>We're in</voice> Got profile: %s", up.Encode())
 
-	if found {
-		t.Logf("<voice>We're in</voice> Got profile: %s", up.Encode())
-	} else {
-		t.Error("Not found :-(")
+	// The filter strips "&=", and it isn't in the template either. (We
+	// could sneak it through as "&&==", but Plan doesn't try that.)
+	_, err = cp.Forge([]byte("email=evil@example.com&=&uid=10&role=admin"))
+	if !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Expected unreachable target, got %v", err)
 	}
+	t.Logf("Unreachable target errored ok: %s", err)
+}
+
+// C13Template is how ProfileFor lays out the profile around the email
+var C13Template = ECBTemplate{
+	Prefix: []byte("email="),
+	Suffix: []byte("&uid=10&role=user"),
+	Filter: func(email []byte) []byte {
+		return []byte(strings.ReplaceAll(string(email), "&=", ""))
+	},
 }
 
 var C13FixedKey = RandomKey()
@@ -346,61 +248,14 @@ func C13EncryptedProfileFor(email string) []byte {
 
 func TestS2C12(t *testing.T) {
 	C12EncryptionOracle := Oracle(C12EncryptionOracleFunc)
-	blockSize := C12EncryptionOracle.FindBlockSize()
-	t.Logf("Enc oracle has block size: %d", blockSize)
-	isECB := C12EncryptionOracle.IsECB(blockSize)
-	t.Logf("Enc oracle is ECB: %v", isECB)
-
-	makeDict := func(targetBlock []byte) map[string]byte {
-		blockDict := make(map[string]byte)
-		workBlock := make([]byte, len(targetBlock)+1)
-		copy(workBlock, targetBlock)
-		for b := 0; b <= 0xff; b++ {
-			workBlock[len(workBlock)-1] = byte(b)
-			buf := C12EncryptionOracle(workBlock)
-			blockDict[string(buf[0:len(workBlock)])] = byte(b)
-		}
-		return blockDict
+	msg, err := C12EncryptionOracle.DecryptSuffix()
+	if err != nil {
+		t.Fatalf("Can't decrypt suffix: %s", err)
 	}
-
-	knownMsg := []byte{}
-NEXT_CHARACTER:
-	for {
-		lenPadding := ((blockSize - 1) - len(knownMsg)) % blockSize
-		if lenPadding < 0 {
-			lenPadding += blockSize
-		}
-		padChunk := make([]byte, lenPadding)
-		buf := C12EncryptionOracle(padChunk)
-
-		// We want last blockSize-1 bytes of knownMsg
-		//
-		targetBlock := make([]byte, blockSize-1)
-		offset := len(knownMsg) - (blockSize - 1)
-		if offset < 0 {
-			copy(targetBlock[-offset:], knownMsg)
-		} else {
-			copy(targetBlock, knownMsg[len(knownMsg)-(blockSize-1):])
-		}
-
-		blockDict := makeDict(targetBlock)
-
-		chunks, slop := BytesToChunks(buf, blockSize)
-		if len(slop) != 0 {
-			panic(fmt.Sprintf("wtf - found slop %v", slop))
-		}
-
-		for _, chunk := range chunks {
-			b, ok := blockDict[string(chunk)]
-			if ok {
-				knownMsg = append(knownMsg, b)
-				//				t.Logf("MSG: %s\n", string(knownMsg))
-				continue NEXT_CHARACTER
-			}
-		}
-		break NEXT_CHARACTER
+	if !strings.HasPrefix(string(msg), "Rollin' in my 5.0") {
+		t.Fatalf("Wrong suffix: %q", msg)
 	}
-	t.Logf("MSG: %s\n", string(knownMsg))
+	t.Logf("MSG: %s\n", string(msg))
 }
 
 var C12FixedKey = RandomKey()
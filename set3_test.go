@@ -170,7 +170,7 @@ func TestS3C20(t *testing.T) {
 		b64str = append(b64str, B64Str(l))
 	}
 	ctxts := C19CryptMsgs(t, b64str)
-	attackRepeatedNonce(t, ctxts)
+	attackRepeatedNonce(t, ctxts, ChiSquaredScorer{TrainNGramModel(1, Hamlet)})
 }
 
 func TestS3C19(t *testing.T) {
@@ -220,54 +220,44 @@ func TestS3C19(t *testing.T) {
 	ctxts := C19CryptMsgs(t, b64msgs)
 	t.Logf("Loaded %d ctxts", len(ctxts))
 
-	attackRepeatedNonce(t, ctxts)
-}
-
-func attackRepeatedNonce(t *testing.T, ctxts [][]byte) {
+	session := attackRepeatedNonce(t, ctxts, EnglishScorer)
 
-	maxLen := 0
-	for _, ct := range ctxts {
-		if len(ct) > maxLen {
-			maxLen = len(ct)
-		}
+	// Only a few messages are long enough to vote on the last keystream
+	// bytes, so fix those up by hand
+	msgIdx := 4
+	crib := []byte("the head")
+	err := session.Place(msgIdx, len(ctxts[msgIdx])-len(crib), crib)
+	if err != nil {
+		t.Fatalf("Can't place crib: %s", err)
 	}
+	msgIdx = 37
+	crib = []byte("his turn,")
+	err = session.Place(msgIdx, len(ctxts[msgIdx])-len(crib), crib)
+	if err != nil {
+		t.Fatalf("Can't place crib: %s", err)
+	}
+	logCribSession(t, session)
+}
 
-	keyStream := make([]byte, maxLen)
-
+func attackRepeatedNonce(t *testing.T, ctxts [][]byte, scorer Scorer) *CribSession {
 	// We wnat to guess the keystream (then use it to XOR-decrypt)
 
 	// A number of things to try:
 	// - ASCII ^ ASCII has bit7 zero (so can get high bit of all KS bytes)
 	// - for each pos, run english score
-
-	for i := 0; i < maxLen; i++ {
-		var msg []byte
-		for _, ct := range ctxts {
-			if len(ct) > i {
-				msg = append(msg, ct[i])
-			}
-		}
-
-		var bestScore float64
-		var bestB byte
-		for b := 0; b < 256; b++ {
-			score := EnglishScore(XorByte(msg, byte(b)))
-			if score > bestScore {
-				bestScore = score
-				bestB = byte(b)
-			}
-		}
-		keyStream[i] = bestB
+	session, err := NewCribSession(ctxts)
+	if err != nil {
+		t.Fatalf("Can't start crib session: %s", err)
 	}
+	session.AutoFill(scorer)
+	logCribSession(t, session)
+	return session
+}
 
-	for i, ct := range ctxts {
-		msg, err := Xor(ct, keyStream[:len(ct)])
-		if err != nil {
-			panic("whoops")
-		}
+func logCribSession(t *testing.T, session *CribSession) {
+	for i, msg := range session.PlainTexts() {
 		t.Logf("%d: %s\n", i, msg)
 	}
-
 	t.Logf("DONE!")
 }
 
@@ -297,31 +287,18 @@ func TestS3C18(t *testing.T) {
 func TestS3C17(t *testing.T) {
 	found := make(map[string]bool)
 
-	blockSize := 16
+	po := PaddingOracle(func(iv, buf []byte) bool {
+		return C17PaddingGood(iv, buf)
+	})
 
 	loopsWithoutFindingNew := 0
 	for loopsWithoutFindingNew < 100 {
 		buf, iv := C17Encrypt()
-		chunks, slop := BytesToChunks(buf, blockSize)
-		if len(slop) != 0 {
-			t.Fatalf("Slop from CBC !!?")
-		}
-
-		po := PaddingOracle(func(iv, buf []byte) bool {
-			return C17PaddingGood(iv, buf)
-		})
-		var plainChunks [][]byte
-		loopIV := iv
-		for i, c := range chunks {
-			plainChunk, err := po.AttackBlock(loopIV, c)
-			if err != nil {
-				t.Fatalf("Can't attack chunk %d: %s", i, err)
-			}
-			//			t.Logf("Got chunk: %s\n", plainChunk)
-			plainChunks = append(plainChunks, plainChunk)
-			loopIV = c
+		msg, _, err := po.Decrypt(iv, buf)
+		if err != nil {
+			t.Fatalf("Can't decrypt: %s", err)
 		}
-		s := string(bytes.Join(plainChunks, []byte{}))
+		s := string(msg)
 
 		if _, ok := found[s]; !ok {
 			//			t.Logf("MSG: %s\n", s)
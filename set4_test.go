@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"net/http"
 	"strings"
@@ -469,12 +470,13 @@ func NewC25Disk(key []byte, plainText []byte) *C25Disk {
 }
 
 func (d *C25Disk) Edit(offset int, plainText []byte) {
-	upTo := offset + len(plainText)
-	ks := AESCTRKeyStream(d.key, d.nonce, upTo)
-	buf, err := Xor(ks[upTo-len(plainText):], plainText)
+	ctr, err := NewAESCTR(d.key, d.nonce)
 	if err != nil {
-		panic("wtf")
+		panic(fmt.Sprintf("Can't create CTR: %s", err))
 	}
+	ctr.Seek(int64(offset), io.SeekStart)
+	buf := make([]byte, len(plainText))
+	ctr.XORKeyStream(buf, plainText)
 	n := copy(d.data[offset:], buf)
 	if n != len(buf) {
 		panic(fmt.Sprintf("Only copied %d bytes, not %d", n, len(buf)))
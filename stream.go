@@ -0,0 +1,237 @@
+package cpals
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// streamChunkSize is how much a streaming reader or writer works on at
+// once. It is a multiple of every block size we use.
+const streamChunkSize = 4096
+
+// cbcWriter encrypts whole blocks as they arrive, keeping back any partial
+// block until more data comes or it is padded on Close
+type cbcWriter struct {
+	w       io.Writer
+	mode    cipher.BlockMode
+	padder  Padder
+	partial []byte
+	scratch []byte
+	err     error
+}
+
+// NewCBCWriter encrypts everything written to it in CBC mode and writes it
+// to w. Close must be called to pad and write the last block. A nil padder
+// means no padding, and Close errors if the data wasn't whole blocks.
+func NewCBCWriter(w io.Writer, b cipher.Block, iv []byte, padder Padder) (io.WriteCloser, error) {
+	mode, err := NewCBCEncrypter(b, iv)
+	if err != nil {
+		return nil, err
+	}
+	bs := b.BlockSize()
+	return &cbcWriter{
+		w:       w,
+		mode:    mode,
+		padder:  padder,
+		partial: make([]byte, 0, bs),
+		scratch: make([]byte, streamChunkSize-streamChunkSize%bs),
+	}, nil
+}
+
+func (c *cbcWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	bs := c.mode.BlockSize()
+	n := len(p)
+
+	// Complete the partial block first
+	if len(c.partial) > 0 {
+		used := copy(c.partial[len(c.partial):bs], p)
+		c.partial = c.partial[:len(c.partial)+used]
+		p = p[used:]
+		if len(c.partial) < bs {
+			return n, nil
+		}
+		if err := c.encrypt(c.partial); err != nil {
+			return 0, err
+		}
+		c.partial = c.partial[:0]
+	}
+
+	for len(p) >= bs {
+		chunk := len(p) - len(p)%bs
+		if chunk > len(c.scratch) {
+			chunk = len(c.scratch)
+		}
+		if err := c.encrypt(p[:chunk]); err != nil {
+			return 0, err
+		}
+		p = p[chunk:]
+	}
+	c.partial = append(c.partial, p...)
+	return n, nil
+}
+
+// encrypt encrypts whole blocks via the scratch buffer, so the caller's
+// data is left alone
+func (c *cbcWriter) encrypt(blocks []byte) error {
+	dst := c.scratch[:len(blocks)]
+	c.mode.CryptBlocks(dst, blocks)
+	if _, err := c.w.Write(dst); err != nil {
+		c.err = fmt.Errorf("Can't write ciphertext: %w", err)
+		return c.err
+	}
+	return nil
+}
+
+// Close pads and writes the last block, then closes the underlying writer
+// if it is an io.Closer
+func (c *cbcWriter) Close() error {
+	if c.err != nil {
+		return c.err
+	}
+	last := c.partial
+	if c.padder != nil {
+		last = c.padder.Pad(c.partial, c.mode.BlockSize())
+	}
+	if len(last)%c.mode.BlockSize() != 0 {
+		c.err = fmt.Errorf("Can't encrypt: %d bytes left over from whole blocks", len(last))
+		return c.err
+	}
+	if err := c.encrypt(last); err != nil {
+		return err
+	}
+	c.err = errors.New("CBC writer is closed")
+	if closer, ok := c.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// cbcReader decrypts as it reads, always keeping back the last block read
+// until it knows whether it is the end of the ciphertext and so padded
+type cbcReader struct {
+	r      io.Reader
+	mode   cipher.BlockMode
+	padder Padder
+	in     []byte
+	inLen  int
+	out    []byte
+	outBuf []byte
+	err    error
+}
+
+// NewCBCReader decrypts the CBC ciphertext read from r. Only the last block
+// is unpadded, with padder, once r reaches EOF. A nil padder means no
+// padding.
+func NewCBCReader(r io.Reader, b cipher.Block, iv []byte, padder Padder) (io.Reader, error) {
+	mode, err := NewCBCDecrypter(b, iv)
+	if err != nil {
+		return nil, err
+	}
+	bs := b.BlockSize()
+	size := streamChunkSize - streamChunkSize%bs
+	return &cbcReader{
+		r:      r,
+		mode:   mode,
+		padder: padder,
+		in:     make([]byte, size),
+		outBuf: make([]byte, size),
+	}, nil
+}
+
+func (c *cbcReader) Read(p []byte) (int, error) {
+	for len(c.out) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.fill()
+	}
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// fill reads more ciphertext and decrypts what it can. At EOF it decrypts
+// and unpads the rest.
+func (c *cbcReader) fill() {
+	bs := c.mode.BlockSize()
+	n, err := c.r.Read(c.in[c.inLen:])
+	c.inLen += n
+	if err == io.EOF {
+		c.finish()
+		return
+	}
+	if err != nil {
+		c.err = err
+		return
+	}
+
+	// Keep back at least one byte, so a whole last block stays here
+	ready := 0
+	if c.inLen > 0 {
+		ready = (c.inLen - 1) / bs * bs
+	}
+	c.decrypt(ready)
+}
+
+func (c *cbcReader) decrypt(ready int) {
+	c.out = c.outBuf[:ready]
+	c.mode.CryptBlocks(c.out, c.in[:ready])
+	c.inLen = copy(c.in, c.in[ready:c.inLen])
+}
+
+func (c *cbcReader) finish() {
+	bs := c.mode.BlockSize()
+	c.err = io.EOF
+	if err := checkFullBlocks(bs, c.in[:c.inLen]); err != nil {
+		c.err = fmt.Errorf("Can't decrypt: %w", err)
+		return
+	}
+	if c.padder == nil {
+		c.decrypt(c.inLen)
+		return
+	}
+	if c.inLen == 0 {
+		c.err = errors.New("Ciphertext ended without a padded block")
+		return
+	}
+	c.decrypt(c.inLen)
+	lastStart := len(c.out) - bs
+	last, err := c.padder.Unpad(c.out[lastStart:], bs)
+	if err != nil {
+		c.out = nil
+		c.err = fmt.Errorf("Can't unpad: %w", err)
+		return
+	}
+	c.out = c.out[:lastStart+len(last)]
+}
+
+// NewAESCTRStream is a cipher.Stream giving the same keystream as
+// AESCTRKeyStream
+func NewAESCTRStream(key []byte, nonce int64) (cipher.Stream, error) {
+	return NewAESCTR(key, nonce)
+}
+
+// NewAESCTRReader decrypts (or encrypts) what it reads from r, matching
+// AESCTR
+func NewAESCTRReader(r io.Reader, key []byte, nonce int64) (io.Reader, error) {
+	s, err := NewAESCTRStream(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.StreamReader{S: s, R: r}, nil
+}
+
+// NewAESCTRWriter encrypts (or decrypts) what is written to it on to w,
+// matching AESCTR. Close closes w if it is an io.Closer.
+func NewAESCTRWriter(w io.Writer, key []byte, nonce int64) (io.WriteCloser, error) {
+	s, err := NewAESCTRStream(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.StreamWriter{S: s, W: w}, nil
+}
@@ -0,0 +1,160 @@
+package cpals
+
+import (
+	"bytes"
+	"crypto/aes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+func TestCBCStream(t *testing.T) {
+	key := RandomKey()
+	iv := RandomBytes(AESBlockSize)
+	block, _ := aes.NewCipher(key)
+
+	for n := 0; n < 5*AESBlockSize; n += 3 {
+		msg := RandomBytes(n)
+		expected := AESCBCEncrypt(key, iv, msg)
+
+		// Write in odd sized pieces
+		w := &bytes.Buffer{}
+		enc, err := NewCBCWriter(w, block, iv, PKCS7Padder{})
+		if err != nil {
+			t.Fatalf("Can't create writer: %s", err)
+		}
+		for i := 0; i < len(msg); i += 7 {
+			end := i + 7
+			if end > len(msg) {
+				end = len(msg)
+			}
+			enc.Write(msg[i:end])
+		}
+		err = enc.Close()
+		if err != nil {
+			t.Fatalf("Can't close: %s", err)
+		}
+		if !BytesEqual(w.Bytes(), expected) {
+			t.Fatalf("%d: got %s expected %s", n, EnHex(w.Bytes()), EnHex(expected))
+		}
+
+		dec, err := NewCBCReader(iotest.OneByteReader(bytes.NewReader(expected)), block, iv, PKCS7Padder{})
+		if err != nil {
+			t.Fatalf("Can't create reader: %s", err)
+		}
+		got, err := ioutil.ReadAll(dec)
+		if err != nil {
+			t.Fatalf("%d: can't read: %s", n, err)
+		}
+		if !BytesEqual(got, msg) {
+			t.Fatalf("%d: got %s expected %s", n, EnHex(got), EnHex(msg))
+		}
+	}
+}
+
+func TestCBCStreamErrors(t *testing.T) {
+	key := RandomKey()
+	iv := RandomBytes(AESBlockSize)
+	block, _ := aes.NewCipher(key)
+
+	enc, _ := NewCBCWriter(ioutil.Discard, block, iv, nil)
+	enc.Write(RandomBytes(AESBlockSize + 1))
+	err := enc.Close()
+	if err == nil {
+		t.Fatalf("Didn't error on unpadded partial block")
+	}
+	t.Logf("errored ok: %s", err)
+
+	ctxt := AESCBCEncrypt(key, iv, []byte("YELLOW SUBMARINE"))
+	testCases := []struct {
+		name string
+		ctxt []byte
+	}{
+		{"empty", nil},
+		{"partial block", ctxt[:len(ctxt)-1]},
+		// Decrypts to rubbish padding
+		{"truncated", ctxt[:AESBlockSize]},
+	}
+	for _, tc := range testCases {
+		dec, _ := NewCBCReader(bytes.NewReader(tc.ctxt), block, iv, PKCS7Padder{})
+		_, err := ioutil.ReadAll(dec)
+		if err == nil {
+			t.Fatalf("%s: didn't error", tc.name)
+		}
+		t.Logf("%s: errored ok: %s", tc.name, err)
+	}
+}
+
+func TestCTRStream(t *testing.T) {
+	key := RandomKey()
+	nonce := int64(1234)
+	msg := RandomBytes(1000)
+	expected := AESCTR(key, nonce, msg)
+
+	r, err := NewAESCTRReader(iotest.HalfReader(bytes.NewReader(msg)), key, nonce)
+	if err != nil {
+		t.Fatalf("Can't create reader: %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Can't read: %s", err)
+	}
+	if !BytesEqual(got, expected) {
+		t.Fatalf("Reader doesn't match AESCTR")
+	}
+
+	buf := &bytes.Buffer{}
+	w, err := NewAESCTRWriter(buf, key, nonce)
+	if err != nil {
+		t.Fatalf("Can't create writer: %s", err)
+	}
+	_, err = io.Copy(w, iotest.OneByteReader(bytes.NewReader(expected)))
+	if err != nil {
+		t.Fatalf("Can't write: %s", err)
+	}
+	if !BytesEqual(buf.Bytes(), msg) {
+		t.Fatalf("Writer doesn't round trip")
+	}
+
+	_, err = NewAESCTRReader(r, key[1:], nonce)
+	if err == nil {
+		t.Fatalf("Didn't error on bad key")
+	}
+}
+
+// zeroReader is an endless source of zeros
+type zeroReader struct{}
+
+func (z zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// Round trip more data than we'd want to hold in memory
+func TestCBCStreamLarge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping large stream in short mode")
+	}
+	key := RandomKey()
+	iv := RandomBytes(AESBlockSize)
+	block, _ := aes.NewCipher(key)
+	size := int64(64 << 20)
+
+	pr, pw := io.Pipe()
+	go func() {
+		enc, _ := NewCBCWriter(pw, block, iv, PKCS7Padder{})
+		io.Copy(enc, io.LimitReader(zeroReader{}, size+5))
+		enc.Close()
+	}()
+	dec, _ := NewCBCReader(pr, block, iv, PKCS7Padder{})
+	n, err := io.Copy(ioutil.Discard, dec)
+	if err != nil {
+		t.Fatalf("Can't round trip: %s", err)
+	}
+	if n != size+5 {
+		t.Fatalf("Round tripped %d bytes, not %d", n, size+5)
+	}
+}
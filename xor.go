@@ -0,0 +1,253 @@
+package cpals
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ByteCandidate is one possible single-byte XOR key and the score of the
+// plaintext it produces.
+type ByteCandidate struct {
+	B     byte
+	Score float64
+}
+
+// ByteCandidates are ranked best first
+type ByteCandidates []ByteCandidate
+
+// Margin is how far ahead the best candidate is of the runner up. A small
+// margin means we shouldn't trust the winner too much.
+func (cs ByteCandidates) Margin() float64 {
+	if len(cs) == 0 {
+		return 0
+	}
+	if len(cs) == 1 {
+		return math.Inf(1)
+	}
+	return cs[0].Score - cs[1].Score
+}
+
+func (cs ByteCandidates) Best() byte {
+	return cs[0].B
+}
+
+// RankSingleByteXor returns the topN key bytes for ctxt, best first
+func RankSingleByteXor(ctxt []byte, scorer Scorer, topN int) ByteCandidates {
+	cs := make(ByteCandidates, 256)
+	for bi := 0; bi <= 0xff; bi++ {
+		b := byte(bi)
+		cs[bi] = ByteCandidate{b, scorer.Score(XorByte(ctxt, b))}
+	}
+	sort.SliceStable(cs, func(i, j int) bool {
+		return cs[i].Score > cs[j].Score
+	})
+	if topN > 0 && topN < len(cs) {
+		cs = cs[:topN]
+	}
+	return cs
+}
+
+// RankRepeatingKeyXor ranks candidates for each position of a repeating
+// key of the given size.
+func RankRepeatingKeyXor(ctxt []byte, keySize int, scorer Scorer, topN int) ([]ByteCandidates, error) {
+	if keySize < 1 {
+		return nil, fmt.Errorf("Bad key size %d", keySize)
+	}
+	if len(ctxt) < keySize {
+		return nil, fmt.Errorf("Ciphertext len %d shorter than key size %d", len(ctxt), keySize)
+	}
+	columns := XorKeyColumns(ctxt, keySize)
+	ranked := make([]ByteCandidates, keySize)
+	for i := range columns {
+		ranked[i] = RankSingleByteXor(columns[i], scorer, topN)
+	}
+	return ranked, nil
+}
+
+// XorKeyColumns splits ctxt into the keySize columns which are each XORd
+// with the same key byte. Unlike transposing BytesToChunks, no trailing
+// bytes are lost.
+func XorKeyColumns(ctxt []byte, keySize int) [][]byte {
+	columns := make([][]byte, keySize)
+	for i, b := range ctxt {
+		columns[i%keySize] = append(columns[i%keySize], b)
+	}
+	return columns
+}
+
+// BeamSearchXorKey combines per-position candidates into whole keys. A
+// beam of full keys (initially all best candidates) is refined one
+// position at a time, re-scoring the whole plaintext for each option, and
+// the best beamWidth keys survive each step.
+func BeamSearchXorKey(ctxt []byte, ranked []ByteCandidates, scorer Scorer, beamWidth int) ([]byte, float64, error) {
+	beam, err := beamSearchXorKeys(ctxt, ranked, scorer, beamWidth)
+	if err != nil {
+		return nil, 0, err
+	}
+	return beam[0].key, beam[0].score, nil
+}
+
+type beamKey struct {
+	key   []byte
+	score float64
+}
+
+// beamSearchXorKeys returns the final beam, best first
+func beamSearchXorKeys(ctxt []byte, ranked []ByteCandidates, scorer Scorer, beamWidth int) ([]beamKey, error) {
+	if len(ranked) == 0 {
+		return nil, errors.New("No key positions to search")
+	}
+	if beamWidth < 1 {
+		beamWidth = 1
+	}
+
+	start := make([]byte, len(ranked))
+	for i := range ranked {
+		if len(ranked[i]) == 0 {
+			return nil, fmt.Errorf("No candidates for position %d", i)
+		}
+		start[i] = ranked[i].Best()
+	}
+	beam := []beamKey{{start, scorer.Score(XorKey(ctxt, start))}}
+
+	for pos := range ranked {
+		var next []beamKey
+		seen := make(map[string]bool)
+		for _, bk := range beam {
+			for _, c := range ranked[pos] {
+				key := make([]byte, len(bk.key))
+				copy(key, bk.key)
+				key[pos] = c.B
+				if seen[string(key)] {
+					continue
+				}
+				seen[string(key)] = true
+				next = append(next, beamKey{key, scorer.Score(XorKey(ctxt, key))})
+			}
+		}
+		sort.SliceStable(next, func(i, j int) bool {
+			return next[i].score > next[j].score
+		})
+		if len(next) > beamWidth {
+			next = next[:beamWidth]
+		}
+		beam = next
+	}
+	return beam, nil
+}
+
+type RepeatingKeyXorOpts struct {
+	// Range of key sizes to consider. Defaults to 2..40
+	MinKeySize, MaxKeySize int
+	// How many of the most likely key sizes to fully solve. Defaults to 5
+	NumKeySizes int
+	// Ranks the key sizes. Defaults to HammingEstimator
+	Estimator KeyLengthEstimator
+	// Defaults to EnglishScorer. A PlainTextModel also prunes key sizes
+	// and pins key bytes using its known plaintext.
+	Scorer Scorer
+	// Candidates kept per key position and the beam width used to combine
+	// them. Default to 3 and 8
+	TopN, BeamWidth int
+}
+
+type RepeatingKeyXorResult struct {
+	Key       []byte
+	PlainText []byte
+	Score     float64
+	// Confidence is the score margin of the winning key over the best
+	// different key we found. Zero means there was no alternative.
+	Confidence float64
+}
+
+// BreakRepeatingKeyXor guesses likely key sizes, solves each with a beam
+// search over ranked per-position candidates and returns the key whose
+// plaintext scores best.
+func BreakRepeatingKeyXor(ctxt []byte, opts RepeatingKeyXorOpts) (RepeatingKeyXorResult, error) {
+	var res RepeatingKeyXorResult
+	if opts.NumKeySizes < 0 || opts.TopN < 0 || opts.BeamWidth < 0 {
+		return res, fmt.Errorf("Bad options: NumKeySizes %d, TopN %d, BeamWidth %d", opts.NumKeySizes, opts.TopN, opts.BeamWidth)
+	}
+	if opts.MinKeySize == 0 {
+		opts.MinKeySize = 2
+	}
+	if opts.MaxKeySize == 0 {
+		opts.MaxKeySize = 40
+	}
+	if opts.NumKeySizes == 0 {
+		opts.NumKeySizes = 5
+	}
+	if opts.Estimator == nil {
+		opts.Estimator = HammingEstimator{}
+	}
+	if opts.Scorer == nil {
+		opts.Scorer = EnglishScorer
+	}
+	if opts.TopN == 0 {
+		opts.TopN = 3
+	}
+	if opts.BeamWidth == 0 {
+		opts.BeamWidth = 8
+	}
+
+	keySizes, err := RankKeyLengths(opts.Estimator, ctxt, opts.MinKeySize, opts.MaxKeySize)
+	if err != nil {
+		return res, fmt.Errorf("Can't guess key size: %w", err)
+	}
+	model, isModel := opts.Scorer.(PlainTextModel)
+	if isModel {
+		keySizes = cribKeySizes(ctxt, keySizes, model)
+		if len(keySizes) == 0 {
+			return res, fmt.Errorf("Known plaintext rules out key sizes %d..%d", opts.MinKeySize, opts.MaxKeySize)
+		}
+	}
+	if len(keySizes) > opts.NumKeySizes {
+		keySizes = keySizes[:opts.NumKeySizes]
+	}
+
+	// A key which is a repeat of a shorter key is the same key, so we
+	// reduce each to its shortest period before comparing
+	var candidates []beamKey
+	seen := make(map[string]bool)
+	for _, keySize := range keySizes {
+		var ranked []ByteCandidates
+		if isModel {
+			ranked, err = rankWithModel(ctxt, keySize, model, opts.TopN)
+		} else {
+			ranked, err = RankRepeatingKeyXor(ctxt, keySize, opts.Scorer, opts.TopN)
+		}
+		if err != nil {
+			return res, fmt.Errorf("Can't rank key size %d: %w", keySize, err)
+		}
+		beam, err := beamSearchXorKeys(ctxt, ranked, opts.Scorer, opts.BeamWidth)
+		if err != nil {
+			return res, fmt.Errorf("Can't search key size %d: %w", keySize, err)
+		}
+		for _, bk := range beam {
+			key := MinimalPeriod(bk.key)
+			if seen[string(key)] {
+				continue
+			}
+			seen[string(key)] = true
+			candidates = append(candidates, beamKey{key, bk.score})
+		}
+	}
+
+	// On a tie, the shorter key is the simpler explanation
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return len(candidates[i].key) < len(candidates[j].key)
+	})
+	best := candidates[0]
+	res.Key = best.key
+	res.PlainText = XorKey(ctxt, best.key)
+	res.Score = best.score
+	if len(candidates) > 1 {
+		res.Confidence = best.score - candidates[1].score
+	}
+	return res, nil
+}
@@ -0,0 +1,120 @@
+package cpals
+
+import (
+	"testing"
+)
+
+func TestRankSingleByteXor(t *testing.T) {
+	msg := []byte("Cooking MC's like a pound of bacon")
+	key := byte('X')
+	ctxt := XorByte(msg, key)
+
+	topN := 5
+	cs := RankSingleByteXor(ctxt, EnglishScorer, topN)
+	if len(cs) != topN {
+		t.Fatalf("Got %d candidates, expected %d", len(cs), topN)
+	}
+	if cs.Best() != key {
+		t.Fatalf("Best candidate %02X expected %02X", cs.Best(), key)
+	}
+	for i := 1; i < len(cs); i++ {
+		if cs[i].Score > cs[i-1].Score {
+			t.Fatalf("Candidates not ranked: %d: %f > %f", i, cs[i].Score, cs[i-1].Score)
+		}
+	}
+	if cs.Margin() <= 0 {
+		t.Fatalf("Expected a positive margin, got %f", cs.Margin())
+	}
+	t.Logf("Best %02X margin %f", cs.Best(), cs.Margin())
+}
+
+func TestBeamSearchXorKey(t *testing.T) {
+	key := []byte("ICE ICE BABY")
+	ctxt := XorKey(Hamlet, key)
+	scorer := LogLikelihoodScorer{TrainNGramModel(2, Hamlet)}
+
+	ranked, err := RankRepeatingKeyXor(ctxt, len(key), scorer, 4)
+	if err != nil {
+		t.Fatalf("Can't rank: %s", err)
+	}
+	got, _, err := BeamSearchXorKey(ctxt, ranked, scorer, 8)
+	if err != nil {
+		t.Fatalf("Can't search: %s", err)
+	}
+	if !BytesEqual(got, key) {
+		t.Fatalf("Got key [%s] expected [%s]", got, key)
+	}
+
+	_, err = RankRepeatingKeyXor([]byte("short"), 10, scorer, 4)
+	if err == nil {
+		t.Fatalf("Didn't error on short ciphertext")
+	}
+	_, _, err = BeamSearchXorKey(ctxt, nil, scorer, 8)
+	if err == nil {
+		t.Fatalf("Didn't error on no positions")
+	}
+}
+
+func TestGuessXorKeySize(t *testing.T) {
+	key := []byte("wokka")
+	ctxt := XorKey(Hamlet, key)
+	sizes, err := GuessXorKeySize(ctxt, 2, 40)
+	if err != nil {
+		t.Fatalf("Can't guess: %s", err)
+	}
+	if sizes[0]%len(key) != 0 {
+		t.Fatalf("Best size %d not a multiple of %d: %v", sizes[0], len(key), sizes[:5])
+	}
+
+	_, err = GuessXorKeySize([]byte("abc"), 2, 40)
+	if err == nil {
+		t.Fatalf("Didn't error on short buffer")
+	}
+	t.Logf("Short buffer errored ok: %s", err)
+	_, err = GuessXorKeySize(ctxt, 10, 2)
+	if err == nil {
+		t.Fatalf("Didn't error on bad range")
+	}
+}
+
+func TestBreakRepeatingKeyXor(t *testing.T) {
+	key := []byte("Terminator X")
+	ctxt := XorKey(Hamlet, key)
+
+	res, err := BreakRepeatingKeyXor(ctxt, RepeatingKeyXorOpts{
+		MinKeySize: 2,
+		MaxKeySize: 30,
+		Scorer:     LogLikelihoodScorer{TrainNGramModel(2, Hamlet)},
+	})
+	if err != nil {
+		t.Fatalf("Can't break: %s", err)
+	}
+	if !BytesEqual(res.Key, key) {
+		t.Fatalf("Got key [%s] expected [%s]", res.Key, key)
+	}
+	if !BytesEqual(res.PlainText, Hamlet) {
+		t.Fatalf("Plaintext mismatch")
+	}
+	t.Logf("Got key [%s] confidence %f", res.Key, res.Confidence)
+
+	res, err = BreakRepeatingKeyXor(ctxt, RepeatingKeyXorOpts{Estimator: CombinedEstimator{}})
+	if err != nil {
+		t.Fatalf("Can't break with combined estimator: %s", err)
+	}
+	if !BytesEqual(res.Key, key) {
+		t.Fatalf("Combined estimator got key [%s] expected [%s]", res.Key, key)
+	}
+
+	_, err = BreakRepeatingKeyXor([]byte("x"), RepeatingKeyXorOpts{})
+	if err == nil {
+		t.Fatalf("Didn't error on tiny ciphertext")
+	}
+
+	for _, opts := range []RepeatingKeyXorOpts{{NumKeySizes: -1}, {TopN: -1}, {BeamWidth: -1}} {
+		_, err = BreakRepeatingKeyXor(ctxt, opts)
+		if err == nil {
+			t.Fatalf("Didn't error on %+v", opts)
+		}
+		t.Logf("errored ok: %s", err)
+	}
+}